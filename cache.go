@@ -0,0 +1,192 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package sqlair
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+
+	"github.com/canonical/sqlair/internal/expr"
+)
+
+// PrepareCacheStats contains statistics about the use of the Prepare cache.
+type PrepareCacheStats struct {
+	// Hits is the number of calls to Prepare that returned a cached
+	// statement.
+	Hits uint64
+	// Misses is the number of calls to Prepare that prepared a new
+	// statement.
+	Misses uint64
+	// Len is the number of statements in the cache.
+	Len int
+	// Size is the maximum number of statements in the cache.
+	Size int
+}
+
+// prepareCache is a bounded least recently used cache of prepared statements,
+// keyed by query string and type samples.
+type prepareCache struct {
+	mutex sync.Mutex
+	size  int
+	// order holds *prepareCacheEntry elements, most recently used first.
+	order   *list.List
+	entries map[string][]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// prepareCacheEntry is a statement in the prepare cache with the arguments it
+// was prepared with.
+type prepareCacheEntry struct {
+	query string
+	// types are the types of the type samples. Entries for statement options
+	// are nil.
+	types []reflect.Type
+	// options are the statement options. Entries for type samples are nil.
+	options []any
+	stmt    *Statement
+}
+
+// cache is the global Prepare cache. It is nil when caching is disabled.
+var cache *prepareCache
+var cacheMutex sync.RWMutex
+
+// SetPrepareCacheSize enables caching of the statements returned by Prepare
+// and MustPrepare, keeping up to size statements. Statements are cached by
+// query string and the types of the type samples, and the same *Statement is
+// returned each time. A size of zero or less disables and clears the cache.
+func SetPrepareCacheSize(size int) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if size <= 0 {
+		cache = nil
+		return
+	}
+	if cache == nil {
+		cache = &prepareCache{order: list.New(), entries: map[string][]*list.Element{}}
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.size = size
+	cache.evict()
+}
+
+// GetPrepareCacheStats returns statistics about the use of the Prepare cache.
+// The zero value is returned if the cache is disabled.
+func GetPrepareCacheStats() PrepareCacheStats {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	if cache == nil {
+		return PrepareCacheStats{}
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return PrepareCacheStats{
+		Hits:   cache.hits,
+		Misses: cache.misses,
+		Len:    cache.order.Len(),
+		Size:   cache.size,
+	}
+}
+
+// getPrepareCache returns the global Prepare cache, or nil if it is disabled.
+func getPrepareCache() *prepareCache {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return cache
+}
+
+// get returns the statement prepared with the query and type samples, if it
+// is in the cache.
+func (c *prepareCache) get(query string, typeSamples []any) (*Statement, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, elem := range c.entries[query] {
+		entry := elem.Value.(*prepareCacheEntry)
+		if entry.matches(typeSamples) {
+			c.order.MoveToFront(elem)
+			c.hits++
+			return entry.stmt, true
+		}
+	}
+	c.misses++
+	return nil, false
+}
+
+// add puts a statement prepared with the query and type samples in the cache,
+// evicting the least recently used statement if the cache is full.
+func (c *prepareCache) add(query string, typeSamples []any, stmt *Statement) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, elem := range c.entries[query] {
+		if elem.Value.(*prepareCacheEntry).matches(typeSamples) {
+			// Another goroutine prepared the same statement.
+			return
+		}
+	}
+	entry := &prepareCacheEntry{
+		query:   query,
+		types:   make([]reflect.Type, len(typeSamples)),
+		options: make([]any, len(typeSamples)),
+		stmt:    stmt,
+	}
+	for i, ts := range typeSamples {
+		if expr.IsOption(ts) {
+			entry.options[i] = ts
+		} else {
+			entry.types[i] = reflect.TypeOf(ts)
+		}
+	}
+	c.entries[query] = append(c.entries[query], c.order.PushFront(entry))
+	c.evict()
+}
+
+// clear removes every statement from the cache.
+func (c *prepareCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.order.Init()
+	c.entries = map[string][]*list.Element{}
+}
+
+// evict removes the least recently used statements until the cache is within
+// its size.
+func (c *prepareCache) evict() {
+	for c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		query := elem.Value.(*prepareCacheEntry).query
+		elems := c.entries[query]
+		for i, e := range elems {
+			if e == elem {
+				elems = append(elems[:i], elems[i+1:]...)
+				break
+			}
+		}
+		if len(elems) == 0 {
+			delete(c.entries, query)
+		} else {
+			c.entries[query] = elems
+		}
+	}
+}
+
+// matches reports whether the entry was prepared with type samples of the
+// same types and the same statement options.
+func (e *prepareCacheEntry) matches(typeSamples []any) bool {
+	if len(typeSamples) != len(e.types) {
+		return false
+	}
+	for i, ts := range typeSamples {
+		if e.options[i] != nil {
+			if !reflect.DeepEqual(e.options[i], ts) {
+				return false
+			}
+		} else if e.types[i] != reflect.TypeOf(ts) {
+			return false
+		}
+	}
+	return true
+}
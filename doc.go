@@ -49,6 +49,26 @@ SQLair input expressions take the following formats:
  2. $Type[:]
     - Type must be a named slice type.
     - Passes all the values in the slice as query parameters.
+    - If the slice is the only value in an IN clause, e.g. "col IN ($Type[:])", and it is empty, an EmptySliceError is returned.
+    - Passing sqlair.EmptyInFalse or sqlair.EmptyInTrue to Prepare renders such an empty IN clause as false or true instead.
+    - The operand of such an IN clause can be a column, a quoted column, a function call such as "lower(name)", or a tuple of columns. Slices in other places are rendered as they are, even when empty.
+    - If the slice elements are structs, the fields tagged with the columns of the IN clause are passed, e.g. "(col1, col2) IN ($Type[:])" renders "(col1, col2) IN ((?, ?), (?, ?))".
+    - Passing sqlair.SQLiteLargeSlices or sqlair.PostgresLargeSlices to Prepare passes a slice in an IN clause that would take the query over the number of parameters the database allows as a single parameter instead. The slices of tuple IN clauses are passed as rows.
+
+ 3. $N
+    - Passes the Nth positional input argument as a query parameter, starting from $1.
+    - Input arguments of types not mentioned in the query are positional, in the order they are passed.
+    - Values can be explicitly marked as positional with sqlair.Arg.
+    - $N in a Postgres dollar quoted string, such as the body of a function, is passed to the database as it is.
+
+ 4. $Type.*
+    - Type must be a struct.
+    - Generates the predicate "col_name1 = ? AND col_name2 = ?" for each tagged field of Type that is not the zero value.
+    - Generates "1=1" if every field is the zero value. Pointer fields can be used to match zero values.
+
+ 5. #Type.col_name
+    - Inserts the string value of col_name into the query as a quoted identifier, for example a column name in an ORDER BY clause.
+    - The identifier must be one of those passed to Prepare with sqlair.Identifiers.
 
 SQLair output expressions can take the following formats:
 
@@ -59,7 +79,8 @@ SQLair output expressions can take the following formats:
 
  2. &Type.*
     - Fetches and sets all the tagged fields of Type.
-    - This form cannot be used with maps.
+    - If Type is a map, every column not read into another output is stored in the map by column name.
+    - Only one map can be used with an asterisk in this way per query.
 
  3. table.* AS &Type.*
     - Does the same as 2 but prepends all columns with the table name.
@@ -71,6 +92,104 @@ SQLair output expressions can take the following formats:
  5. (col_name1, col_name2) AS (&Type.other_col1, &Type.other_col2)
     - Fetches the columns from the database and stores them at other_col1 and other_col2 in Type.
 
+ 6. col_name AS &_
+    - Fetches the column into an anonymous output.
+    - Anonymous outputs are filled, in order, from pointers to plain values (such as *int64 or *time.Time) passed alongside the struct and map outputs.
+    - Columns must be specified, e.g. "count(*) AS &_" or "(name, id) AS (&_, &_)".
+
 Multiple input and output expressions can be written in a single query.
+Types with the same name from different packages can be used together by qualifying the type with its package name, e.g. "$state.Model.uuid" or "&cloud.Model.*".
+Unqualified names must then be unambiguous.
+An input value referenced more than once in a query is passed to the database as a single query parameter.
+
+Parts of a query can be made conditional by enclosing them in double braces:
+
+	SELECT &Person.*
+	FROM person
+	WHERE manager_name = $Manager.name
+	{{ AND team = $Filter.team }}
+
+The section is dropped from the query when any input expression inside it has no value.
+That is, when the value is the zero value of its type, nil, an empty slice, or a key missing from a map.
+Conditional sections must contain at least one input expression, cannot contain output expressions, and cannot be nested.
+
+# Column mapping
+
+By default the column of a struct field is given by its `db` tag, and fields without a tag are ignored.
+A sqlair.Mapper passed to Prepare, or set as the default with sqlair.SetDefaultMapper, can read the tags from another key and map the names of untagged exported fields to columns:
+
+	mapper := &sqlair.Mapper{TagKey: "sql", ColumnName: sqlair.SnakeCase}
+
+With this mapper a field "UserID" without a tag is read from the column "user_id".
+Fields tagged with "-" are always ignored.
+
+# Custom types
+
+Struct fields of types that do not implement driver.Valuer and sql.Scanner, such as types from other modules, can be used in input and output expressions once a converter for the type is registered with sqlair.RegisterConverter.
+The converter also applies to the elements of slices in "$Type[:]" input expressions.
+
+Struct fields whose tag has the "json" option, e.g. `db:"settings,json"`, are stored as JSON text.
+They are encoded with encoding/json when used as inputs and decoded when read as outputs.
+Nil maps, slices and pointers are stored as NULL, and NULL is read as the zero value of the field.
+
+Slice fields of strings, numbers or booleans can be stored in other forms with the tag options:
+  - `db:"tags,array"` stores the slice in an array column.
+    It is passed as a JSON array, or as a Postgres array literal if sqlair.DialectPostgres is passed to Prepare.
+    Results are read from either form.
+  - `db:"ids,split=,"` stores the slice as its elements joined by the separator following "split=", which must be the last option.
+    This reads lists aggregated with GROUP_CONCAT, e.g. "SELECT GROUP_CONCAT(id) AS &Team.ids".
+
+# NULL results
+
+By default, NULL is read into outputs that cannot be set to nil, such as string struct fields, as their zero value.
+The tag options of a struct field change this:
+  - `db:"name,notnull"` makes reading NULL into the field return a *sqlair.NullError naming the column.
+  - `db:"count,default=1"` sets the field to the given value when the column is NULL.
+    Defaults can be given for fields of string, boolean and numeric types, and pointers to them.
+
+Passing sqlair.StrictNulls to Prepare makes every output that cannot be set to nil and has no default reject NULL with a *sqlair.NullError.
+
+# Type registry
+
+Types used in many statements can be registered once with a sqlair.Registry rather than passed to every call to Prepare:
+
+	reg := sqlair.NewRegistry()
+	err := reg.Register(Person{}, Address{})
+	...
+	stmt, err := reg.Prepare("SELECT &Person.* FROM person WHERE address_id = $Address.id")
+
+A Registry can be held globally, or the registry attached to a DB can be used with DB.Registry.
+Further type samples and statement options can be passed to Registry.Prepare.
+
+# Statement introspection
+
+A prepared statement describes the Go values it references.
+Statement.Inputs and Statement.Outputs list the package qualified type name, such as "state.Model", struct field or map key, Go type and column of each input and output, and Statement.Types lists the argument types.
+Statement.SQLTemplate returns the SQL of the statement with the output expressions expanded into their columns and the input expressions as written.
+
+# Statement cache
+
+Prepare parses the query and checks the types each time it is called.
+A bounded cache of prepared statements, keyed by query string and type samples, can be enabled with sqlair.SetPrepareCacheSize.
+Cached statements are immutable and can be shared between goroutines.
+Cache hits and misses are reported by sqlair.GetPrepareCacheStats.
+
+# Running statements
+
+The SQL sent to the database is generated from the input arguments each time a statement is run.
+Query.Rebind returns a copy of a query with new input arguments, and Statement.Bind returns a BoundStatement that runs a statement on a DB many times.
+Both reuse the previously generated SQL when the new input arguments generate the same SQL, such as slices of the same lengths, and only refresh the query parameters.
+
+DB.ExecMany and TX.ExecMany execute a statement without outputs once for each set of input arguments in a sequence, preparing its SQL on the database only once.
+The executions continue after a failure and the error of each failing set is reported, unless sqlair.ExecStopOnError is passed.
+DB.Exec and TX.Exec run a script of several statements separated by semicolons, with the input arguments passed to the statements that reference their types.
+Semicolons in string literals, comments, the BEGIN ... END bodies of CREATE TRIGGER statements and Postgres dollar quoted strings do not separate statements.
+Input expressions in dollar quoted strings, such as $1 in the body of a plpgsql function, are passed to the database as they are.
+
+# Multiple result sets
+
+Queries returning several result sets, such as calls to stored procedures, are read set by set with Iterator.NextResultSet.
+Each result set can contain the columns of a different subset of the output expressions.
+Query.GetAll fills each slice from the result sets containing the columns of its outputs.
 */
 package sqlair
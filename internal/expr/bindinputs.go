@@ -6,6 +6,7 @@ package expr
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -22,70 +23,550 @@ type TypeBoundExpr []any
 // BindInputs takes the SQLair input arguments and returns the PrimedQuery ready
 // for use with the database.
 func (tbe *TypeBoundExpr) BindInputs(args ...any) (pq *PrimedQuery, err error) {
-	defer func() {
-		if err != nil {
-			err = fmt.Errorf("invalid input parameter: %s", err)
-		}
-	}()
+	b, err := tbe.bindInputs(args, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input parameter: %w", err)
+	}
+	return &PrimedQuery{
+		outputs:            b.outputs,
+		outputColumns:      b.outputColumns,
+		nullResults:        b.nullResults,
+		columnsOutput:      b.columnsOutput,
+		anonymousPositions: b.anonymousPositions,
+		sql:                b.sql.String(),
+		params:             b.params,
+		shape:              b.shape,
+	}, nil
+}
 
-	typeToValue, err := typeinfo.ValidateInputs(args)
+// RebindInputs takes new SQLair input arguments for a PrimedQuery previously
+// generated from the TypeBoundExpr and returns a PrimedQuery for them. If the
+// new arguments generate the same SQL, for example because their slices
+// have the same lengths, the SQL of pq is reused and only the query
+// parameters are generated.
+func (tbe *TypeBoundExpr) RebindInputs(pq *PrimedQuery, args ...any) (*PrimedQuery, error) {
+	b, err := tbe.bindInputs(args, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input parameter: %w", err)
+	}
+	if !sameShape(b.shape, pq.shape) {
+		return tbe.BindInputs(args...)
+	}
+	rebound := *pq
+	rebound.params = b.params
+	return &rebound, nil
+}
+
+// bindInputs generates the query parameters from the input arguments, and
+// the SQL if render is true.
+func (tbe *TypeBoundExpr) bindInputs(args []any, render bool) (*inputBinder, error) {
+	typedArgs, positionalArgs := tbe.splitPositionalArgs(args)
+	typeToValue, err := typeinfo.ValidateInputs(typedArgs)
 	if err != nil {
 		return nil, err
 	}
 
+	// A slice in an IN clause is only passed as a single query parameter if
+	// the query would otherwise have more parameters than the database
+	// allows, so the parameters of the whole query are counted first.
+	totalParams := -1
+	if tbe.hasLargeSlices() {
+		counter := newInputBinder(typeToValue, positionalArgs, false, -1)
+		for _, te := range *tbe {
+			if err := counter.bind(te); err != nil {
+				return nil, err
+			}
+		}
+		totalParams = len(counter.params)
+	}
+
 	// Generate SQL and query parameters.
-	var params []any
-	var outputs []typeinfo.Output
+	b := newInputBinder(typeToValue, positionalArgs, render, totalParams)
+	for _, te := range *tbe {
+		if err := b.bind(te); err != nil {
+			return nil, err
+		}
+	}
+
+	for argType := range typeToValue {
+		if !b.argTypeUsed[argType] {
+			return nil, fmt.Errorf("%q not referenced in query", argType.Name())
+		}
+	}
+	for i, used := range b.positionalUsed {
+		if !used {
+			return nil, fmt.Errorf("positional argument $%d not referenced in query", i+1)
+		}
+	}
+	return b, nil
+}
+
+// hasLargeSlices reports whether the slice of any IN clause can be passed as
+// a single query parameter.
+func (tbe *TypeBoundExpr) hasLargeSlices() bool {
+	for _, te := range tbe.typedExprs() {
+		if ie, ok := te.(*typedInExpr); ok && ie.largeSlices != LargeSlicesParams {
+			return true
+		}
+	}
+	return false
+}
+
+// sameShape reports whether two shapes recorded by an inputBinder are the
+// same, and so generate the same SQL.
+func sameShape(a, b []any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inputBinder holds the state used by BindInputs to generate the SQL and
+// query parameters from the typed expressions.
+type inputBinder struct {
+	typeToValue    typeinfo.TypeToValue
+	positionalArgs []any
 	// argTypeUsed is used to check that all the query parameters are
 	// referenced in the query.
-	argTypeUsed := map[reflect.Type]bool{}
-	inputCount := 0
-	outputCount := 0
-	sqlStr := bytes.Buffer{}
-	for _, te := range *tbe {
-		switch te := te.(type) {
-		case *typedInputExpr:
-			vals, err := te.input.LocateParams(typeToValue)
-			if err != nil {
-				return nil, err
+	argTypeUsed        map[reflect.Type]bool
+	positionalUsed     []bool
+	params             []any
+	outputs            []typeinfo.Output
+	outputColumns      []string
+	nullResults        NullResults
+	columnsOutput      typeinfo.ColumnsOutput
+	anonymousPositions map[int]int
+	// paramNames maps the keys of the values passed as query parameters to
+	// the parameter names.
+	paramNames  map[string]string
+	outputCount int
+	// totalParams is the number of query parameters the query has if every
+	// slice element is passed as a parameter, less the parameters saved by
+	// the slices already passed as single parameters. It is -1 if the
+	// slices are never passed as single parameters.
+	totalParams int
+	// render is false if the SQL is not generated.
+	render bool
+	sql    bytes.Buffer
+	// shape records every property of the input arguments that the SQL
+	// depends on, such as the lengths of slices and which conditional
+	// sections are included.
+	shape []any
+}
+
+// newInputBinder returns an inputBinder for the input arguments.
+func newInputBinder(typeToValue typeinfo.TypeToValue, positionalArgs []any, render bool, totalParams int) *inputBinder {
+	return &inputBinder{
+		typeToValue:        typeToValue,
+		positionalArgs:     positionalArgs,
+		argTypeUsed:        map[reflect.Type]bool{},
+		positionalUsed:     make([]bool, len(positionalArgs)),
+		anonymousPositions: map[int]int{},
+		paramNames:         map[string]string{},
+		totalParams:        totalParams,
+		render:             render,
+	}
+}
+
+// write writes a string to the generated SQL.
+func (b *inputBinder) write(s string) {
+	if b.render {
+		b.sql.WriteString(s)
+	}
+}
+
+// bind writes the SQL for a typed expression and adds any query parameters it
+// references.
+func (b *inputBinder) bind(te any) error {
+	switch te := te.(type) {
+	case *typedInputExpr:
+		vals, err := te.input.LocateParams(b.typeToValue)
+		if err != nil {
+			return err
+		}
+		b.argTypeUsed[te.input.ArgType()] = true
+		b.shape = append(b.shape, len(vals))
+		for i, val := range vals {
+			if i != 0 {
+				b.write(", ")
 			}
-			argTypeUsed[te.input.ArgType()] = true
-			for i, val := range vals {
+			b.addParam(elemKey(te.input, i), typeinfo.DialectParam(val.Interface(), te.dialect))
+		}
+	case *typedInExpr:
+		vals, err := te.input.LocateParams(b.typeToValue)
+		if err != nil {
+			return err
+		}
+		b.argTypeUsed[te.input.ArgType()] = true
+		b.shape = append(b.shape, len(vals))
+		if len(vals) == 0 {
+			if te.emptyIn == EmptyInError {
+				return &EmptySliceError{SliceType: te.input.ArgType().Name()}
+			}
+			// A NOT IN clause takes the opposite value to an IN clause.
+			if (te.emptyIn == EmptyInTrue) != te.not {
+				b.write("1=1")
+			} else {
+				b.write("1=0")
+			}
+			return nil
+		}
+		b.write(te.prefix)
+		large := b.totalParams > te.largeSlices.maxParams()
+		b.shape = append(b.shape, large)
+		switch {
+		case large:
+			n := len(b.params)
+			if err := b.addSliceParam(te, vals); err != nil {
+				return err
+			}
+			b.totalParams -= len(vals) - (len(b.params) - n)
+		case te.tupleSize > 1:
+			for i := 0; i < len(vals); i += te.tupleSize {
 				if i != 0 {
-					sqlStr.WriteString(", ")
+					b.write(", ")
 				}
-				namedInput := sql.Named("sqlair_"+strconv.Itoa(inputCount), val.Interface())
-				params = append(params, namedInput)
-				sqlStr.WriteString("@sqlair_" + strconv.Itoa(inputCount))
-				inputCount++
-			}
-		case *typedOutputExpr:
-			for i, oc := range te.outputColumns {
-				sqlStr.WriteString(oc.sql(outputCount))
-				if i != len(te.outputColumns)-1 {
-					sqlStr.WriteString(", ")
+				b.write("(")
+				for j, val := range vals[i : i+te.tupleSize] {
+					if j != 0 {
+						b.write(", ")
+					}
+					b.addParam(elemKey(te.input, i+j), typeinfo.DialectParam(val.Interface(), te.dialect))
+				}
+				b.write(")")
+			}
+		default:
+			for i, val := range vals {
+				if i != 0 {
+					b.write(", ")
 				}
-				outputCount++
-				outputs = append(outputs, oc.output)
+				b.addParam(elemKey(te.input, i), typeinfo.DialectParam(val.Interface(), te.dialect))
+			}
+		}
+		b.write(te.suffix)
+	case *typedPredicateInputExpr:
+		var predicates []string
+		for i, input := range te.inputs {
+			vals, err := input.LocateParams(b.typeToValue)
+			if err != nil {
+				return err
+			}
+			b.argTypeUsed[input.ArgType()] = true
+			zero := vals[0].IsZero()
+			b.shape = append(b.shape, zero)
+			if zero {
+				continue
 			}
-		case *bypass:
-			sqlStr.WriteString(te.chunk)
+			predicates = append(predicates, te.columns[i]+" = "+b.param(elemKey(input, 0), typeinfo.DialectParam(vals[0].Interface(), te.dialect)))
+		}
+		switch len(predicates) {
+		case 0:
+			b.write("1=1")
+		case 1:
+			b.write(predicates[0])
 		default:
-			return nil, fmt.Errorf("internal error: unknown expression type %T", te)
+			b.write("(" + strings.Join(predicates, " AND ") + ")")
+		}
+	case *typedPositionalInputExpr:
+		arg, err := b.positionalArg(te.position)
+		if err != nil {
+			return err
+		}
+		b.addParam("$"+strconv.Itoa(te.position), arg)
+	case *typedIdentifierInputExpr:
+		vals, err := te.input.LocateParams(b.typeToValue)
+		if err != nil {
+			return err
+		}
+		b.argTypeUsed[te.input.ArgType()] = true
+		val := vals[0]
+		if val.Kind() == reflect.Interface && !val.IsNil() {
+			val = val.Elem()
+		}
+		if val.Kind() != reflect.String {
+			return fmt.Errorf("%s: need string identifier, got %s", te.input.Desc(), val.Kind())
+		}
+		id := val.String()
+		if !te.allowed[id] {
+			return fmt.Errorf("%s: identifier %q not allowed", te.input.Desc(), id)
+		}
+		b.shape = append(b.shape, id)
+		b.write(quoteIdentifier(id))
+	case *typedConditionalExpr:
+		include, err := b.include(te)
+		if err != nil {
+			return err
+		}
+		b.shape = append(b.shape, include)
+		if !include {
+			return nil
+		}
+		for _, ne := range te.exprs {
+			if err := b.bind(ne); err != nil {
+				return err
+			}
 		}
+	case *typedOutputExpr:
+		for i, oc := range te.outputColumns {
+			b.write(oc.sql(b.outputCount))
+			if i != len(te.outputColumns)-1 {
+				b.write(", ")
+			}
+			if oc.output == nil {
+				b.anonymousPositions[b.outputCount] = len(b.anonymousPositions)
+			}
+			b.outputCount++
+			b.outputs = append(b.outputs, oc.output)
+			b.outputColumns = append(b.outputColumns, oc.column)
+		}
+		b.nullResults = te.nullResults
+	case *typedColumnsOutputExpr:
+		b.write(te.column)
+		b.columnsOutput = te.output
+	case *bypass:
+		b.write(te.chunk)
+	default:
+		return fmt.Errorf("internal error: unknown expression type %T", te)
 	}
+	return nil
+}
 
-	for argType := range typeToValue {
-		if !argTypeUsed[argType] {
-			return nil, fmt.Errorf("%q not referenced in query", argType.Name())
+// param returns the name of the query parameter for a value, adding the
+// parameter if needed. Values with the same non-empty key are the same value
+// referenced more than once in the query, so they share a single parameter.
+func (b *inputBinder) param(key string, val any) string {
+	if key != "" {
+		if name, ok := b.paramNames[key]; ok {
+			return name
 		}
 	}
+	name := "sqlair_" + strconv.Itoa(len(b.params))
+	b.params = append(b.params, sql.Named(name, val))
+	if key != "" {
+		b.paramNames[key] = "@" + name
+	}
+	return "@" + name
+}
 
-	return &PrimedQuery{outputs: outputs, sql: sqlStr.String(), params: params}, nil
+// addParam writes the name of the query parameter for a value to the SQL.
+func (b *inputBinder) addParam(key string, val any) {
+	b.write(b.param(key, val))
+}
+
+// elemKey returns the key identifying the ith value located by an input.
+func elemKey(input typeinfo.Input, i int) string {
+	return input.Identifier() + "#" + strconv.Itoa(i)
+}
+
+// addSliceParam adds the values of the slice in an IN clause as a single
+// query parameter and writes the SQL that expands it into rows.
+func (b *inputBinder) addSliceParam(te *typedInExpr, vals []reflect.Value) error {
+	size := te.tupleSize
+	if size < 1 {
+		size = 1
+	}
+	switch te.largeSlices {
+	case LargeSlicesJSONEach:
+		// The elements of a tuple IN clause are passed as a JSON array of
+		// rows and extracted column by column.
+		elems := make([]any, 0, len(vals)/size)
+		for i := 0; i < len(vals); i += size {
+			if size == 1 {
+				elems = append(elems, vals[i].Interface())
+				continue
+			}
+			row := make([]any, size)
+			for j := range row {
+				row[j] = vals[i+j].Interface()
+			}
+			elems = append(elems, row)
+		}
+		js, err := json.Marshal(elems)
+		if err != nil {
+			return fmt.Errorf("cannot encode %s as JSON: %s", te.input.Desc(), err)
+		}
+		if size == 1 {
+			b.write("SELECT value")
+		} else {
+			b.write("SELECT ")
+			for j := 0; j < size; j++ {
+				if j != 0 {
+					b.write(", ")
+				}
+				b.write("json_extract(value, '$[" + strconv.Itoa(j) + "]')")
+			}
+		}
+		b.write(" FROM json_each(")
+		b.addParam("", string(js))
+		b.write(")")
+	case LargeSlicesUnnest:
+		if size == 1 {
+			// The slice is passed to the driver as an unnamed slice type. The
+			// elements converted with a registered converter are interface
+			// values, so the type of the values is used.
+			s := reflect.MakeSlice(reflect.SliceOf(vals[0].Type()), len(vals), len(vals))
+			for i, val := range vals {
+				s.Index(i).Set(val)
+			}
+			b.write("SELECT unnest(")
+			b.addParam("", s.Interface())
+			b.write(")")
+			return nil
+		}
+		// The elements of a tuple IN clause are passed as an array for each
+		// column, which unnest zips back into rows.
+		b.write("SELECT * FROM unnest(")
+		n := len(vals) / size
+		for j := 0; j < size; j++ {
+			if j != 0 {
+				b.write(", ")
+			}
+			s := reflect.MakeSlice(reflect.SliceOf(vals[j].Type()), n, n)
+			for i := 0; i < n; i++ {
+				s.Index(i).Set(vals[i*size+j])
+			}
+			b.addParam("", s.Interface())
+		}
+		b.write(")")
+	default:
+		return fmt.Errorf("internal error: unknown large slice rendering %d", te.largeSlices)
+	}
+	return nil
+}
+
+// positionalArg returns the positional argument at the given position and
+// marks it as used.
+func (b *inputBinder) positionalArg(position int) (any, error) {
+	if position > len(b.positionalArgs) {
+		return nil, fmt.Errorf("positional argument $%d missing (have %d)", position, len(b.positionalArgs))
+	}
+	b.positionalUsed[position-1] = true
+	return b.positionalArgs[position-1], nil
+}
+
+// include reports whether a conditional section is included in the query. The
+// section is dropped if the value of any of its input expressions is missing
+// from a map, nil, zero or an empty slice. The input arguments referenced in a
+// dropped section still count as used.
+func (b *inputBinder) include(te *typedConditionalExpr) (bool, error) {
+	include := true
+	for _, ne := range te.exprs {
+		var input typeinfo.Input
+		switch ne := ne.(type) {
+		case *typedInputExpr:
+			input = ne.input
+		case *typedIdentifierInputExpr:
+			input = ne.input
+		case *typedInExpr:
+			input = ne.input
+		case *typedPredicateInputExpr:
+			if _, err := ne.inputs[0].LocateParams(b.typeToValue); err != nil {
+				return false, err
+			}
+			b.argTypeUsed[ne.inputs[0].ArgType()] = true
+		case *typedPositionalInputExpr:
+			arg, err := b.positionalArg(ne.position)
+			if err != nil {
+				return false, err
+			}
+			if arg == nil || reflect.ValueOf(arg).IsZero() {
+				include = false
+			}
+		}
+		if input != nil {
+			zero, err := typeinfo.InputIsZero(input, b.typeToValue)
+			if err != nil {
+				return false, err
+			}
+			b.argTypeUsed[input.ArgType()] = true
+			if zero {
+				include = false
+			}
+		}
+	}
+	return include, nil
+}
+
+// quoteIdentifier quotes each dot separated part of an identifier with
+// double quotes, escaping any double quotes within it.
+func quoteIdentifier(id string) string {
+	parts := strings.Split(id, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// PositionalArg wraps an input argument that is to be used as a positional
+// argument, for the "$N" input expressions, regardless of its type.
+type PositionalArg struct {
+	Value any
+}
+
+// InputTypes returns the types of the struct, map and slice input arguments
+// referenced in the query.
+func (tbe *TypeBoundExpr) InputTypes() map[reflect.Type]bool {
+	inputTypes, _ := tbe.inputTypes()
+	return inputTypes
+}
+
+// inputTypes returns the types of the struct, map and slice input arguments
+// referenced in the query, and whether it contains positional input
+// expressions.
+func (tbe *TypeBoundExpr) inputTypes() (inputTypes map[reflect.Type]bool, hasPositional bool) {
+	inputTypes = map[reflect.Type]bool{}
+	for _, te := range tbe.typedExprs() {
+		switch te := te.(type) {
+		case *typedInputExpr:
+			inputTypes[te.input.ArgType()] = true
+		case *typedPredicateInputExpr:
+			inputTypes[te.inputs[0].ArgType()] = true
+		case *typedIdentifierInputExpr:
+			inputTypes[te.input.ArgType()] = true
+		case *typedInExpr:
+			inputTypes[te.input.ArgType()] = true
+		case *typedPositionalInputExpr:
+			hasPositional = true
+		}
+	}
+	return inputTypes, hasPositional
+}
+
+// splitPositionalArgs separates the positional input arguments from the
+// struct, map and slice input arguments. If the query contains positional
+// input expressions, arguments of types not referenced in the query are
+// positional. Arguments wrapped in PositionalArg are always positional.
+func (tbe *TypeBoundExpr) splitPositionalArgs(args []any) (typedArgs []any, positionalArgs []any) {
+	inputTypes, hasPositional := tbe.inputTypes()
+	for _, arg := range args {
+		if pa, ok := arg.(PositionalArg); ok {
+			positionalArgs = append(positionalArgs, pa.Value)
+			continue
+		}
+		if hasPositional {
+			t := reflect.TypeOf(arg)
+			if t != nil && t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			if !inputTypes[t] {
+				positionalArgs = append(positionalArgs, arg)
+				continue
+			}
+		}
+		typedArgs = append(typedArgs, arg)
+	}
+	return typedArgs, positionalArgs
 }
 
 // outputColumn stores the name of a column to fetch from the database and the
-// output type location specifying the value to scan the result into.
+// output type location specifying the value to scan the result into. The
+// output is nil for anonymous outputs, which are located by their position
+// in the output arguments.
 type outputColumn struct {
 	output typeinfo.Output
 	column string
@@ -107,13 +588,84 @@ func newOutputColumn(tableName string, columnName string, output typeinfo.Output
 
 // typedInputExpr stores information about a Go value to use as a query input.
 type typedInputExpr struct {
+	input   typeinfo.Input
+	dialect typeinfo.Dialect
+	raw     string
+}
+
+// typedPredicateInputExpr stores information about the fields of a struct
+// used to generate equality predicates on their columns.
+type typedPredicateInputExpr struct {
+	inputs  []typeinfo.Input
+	columns []string
+	dialect typeinfo.Dialect
+	raw     string
+}
+
+// typedPositionalInputExpr stores the position of a positional input
+// argument to use as a query input.
+type typedPositionalInputExpr struct {
+	position int
+	raw      string
+}
+
+// typedIdentifierInputExpr stores information about a Go value to insert into
+// the query as an identifier, and the identifiers it is allowed to take.
+type typedIdentifierInputExpr struct {
+	input   typeinfo.Input
+	allowed map[string]bool
+	raw     string
+}
+
+// typedInExpr stores information about the slice in an IN clause and how to
+// render the clause if the slice is empty.
+type typedInExpr struct {
 	input typeinfo.Input
+	// tupleSize is the number of columns in the operand of the clause, and
+	// so the number of parameters in each tuple.
+	tupleSize   int
+	prefix      string
+	suffix      string
+	not         bool
+	emptyIn     EmptyIn
+	largeSlices LargeSlices
+	dialect     typeinfo.Dialect
+	// raw is the whole IN clause, e.g. "col IN ($S[:])".
+	raw string
+}
+
+// EmptySliceError is returned by BindInputs when the slice in an IN clause,
+// "col IN ($S[:])", is empty and no EmptyIn option was passed to BindTypes.
+type EmptySliceError struct {
+	// SliceType is the name of the slice type.
+	SliceType string
+}
+
+func (e *EmptySliceError) Error() string {
+	return fmt.Sprintf("empty slice %q in IN clause", e.SliceType)
+}
+
+// typedConditionalExpr contains the typed expressions of a conditional
+// section of the query.
+type typedConditionalExpr struct {
+	exprs []any
+	raw   string
 }
 
 // typedOutputExpr contains the columns to fetch from the database and
 // information about the Go values to read the query results into.
 type typedOutputExpr struct {
 	outputColumns []outputColumn
+	nullResults   NullResults
+}
+
+// typedColumnsOutputExpr contains the asterisk column to fetch from the
+// database and information about the map to read every unbound column of the
+// results into.
+type typedColumnsOutputExpr struct {
+	// column is the asterisk column, optionally prefixed with a table name.
+	column string
+	output typeinfo.ColumnsOutput
 }
 
 const markerPrefix = "_sqlair_"
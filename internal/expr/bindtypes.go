@@ -6,6 +6,8 @@ package expr
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"reflect"
 
 	"github.com/canonical/sqlair/internal/typeinfo"
 )
@@ -41,7 +43,35 @@ func (pe *ParsedExpr) BindTypes(args ...any) (tbe *TypeBoundExpr, err error) {
 		}
 	}()
 
-	argInfo, err := typeinfo.GenerateArgInfo(args)
+	var typeSamples []any
+	allowedIdentifiers := map[string]bool{}
+	emptyIn := EmptyInError
+	largeSlices := LargeSlicesParams
+	dialect := typeinfo.DialectSQLite
+	nullResults := NullResultsZero
+	mapper := typeinfo.DefaultMapper()
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case Identifiers:
+			for _, id := range arg {
+				allowedIdentifiers[id] = true
+			}
+		case EmptyIn:
+			emptyIn = arg
+		case LargeSlices:
+			largeSlices = arg
+		case typeinfo.Dialect:
+			dialect = arg
+		case NullResults:
+			nullResults = arg
+		case *typeinfo.Mapper:
+			mapper = arg
+		default:
+			typeSamples = append(typeSamples, arg)
+		}
+	}
+
+	argInfo, err := mapper.GenerateArgInfo(typeSamples)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +79,7 @@ func (pe *ParsedExpr) BindTypes(args ...any) (tbe *TypeBoundExpr, err error) {
 	// Bind types to each expression.
 	var typedExprs TypeBoundExpr
 	outputUsed := map[string]bool{}
+	columnsOutputUsed := false
 	for _, expr := range pe.exprs {
 		te, err := expr.bindTypes(argInfo)
 		if err != nil {
@@ -57,15 +88,52 @@ func (pe *ParsedExpr) BindTypes(args ...any) (tbe *TypeBoundExpr, err error) {
 
 		if toe, ok := te.(*typedOutputExpr); ok {
 			for _, oc := range toe.outputColumns {
+				if oc.output == nil {
+					// Anonymous outputs are located by position so they
+					// cannot be duplicated.
+					continue
+				}
 				if ok := outputUsed[oc.output.Identifier()]; ok {
 					return nil, fmt.Errorf("%s appears more than once in output expressions", oc.output.Desc())
 				}
 				outputUsed[oc.output.Identifier()] = true
 			}
 		}
+		if _, ok := te.(*typedColumnsOutputExpr); ok {
+			if columnsOutputUsed {
+				return nil, fmt.Errorf("cannot read all columns into more than one map")
+			}
+			columnsOutputUsed = true
+		}
 		typedExprs = append(typedExprs, te)
 	}
 
+	for _, te := range typedExprs {
+		nested := []any{te}
+		if tce, ok := te.(*typedConditionalExpr); ok {
+			nested = tce.exprs
+		}
+		for _, te := range nested {
+			switch te := te.(type) {
+			case *typedIdentifierInputExpr:
+				if len(allowedIdentifiers) == 0 {
+					return nil, fmt.Errorf("input expression: no identifiers allowed, use sqlair.Identifiers: %s", te.raw)
+				}
+				te.allowed = allowedIdentifiers
+			case *typedInExpr:
+				te.emptyIn = emptyIn
+				te.largeSlices = largeSlices
+				te.dialect = dialect
+			case *typedInputExpr:
+				te.dialect = dialect
+			case *typedPredicateInputExpr:
+				te.dialect = dialect
+			case *typedOutputExpr:
+				te.nullResults = nullResults
+			}
+		}
+	}
+
 	return &typedExprs, nil
 }
 
@@ -75,7 +143,7 @@ type expression interface {
 	String() string
 
 	// bindTypes binds the types to the expression to generate either a
-	// *typedInputExpr or *typedOutputExpr.
+	// *typedInputExpr, *typedOutputExpr or *typedColumnsOutputExpr.
 	bindTypes(typeinfo.ArgInfo) (any, error)
 }
 
@@ -115,7 +183,7 @@ func (e *memberInputExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("input expression: %s: %s", err, e.raw)
 	}
-	return &typedInputExpr{input}, nil
+	return &typedInputExpr{input: input, raw: e.raw}, nil
 }
 
 // sliceInputExpr is an input expression of the form "$S[:]" that represents a
@@ -137,7 +205,216 @@ func (e *sliceInputExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
 	if err != nil {
 		return nil, fmt.Errorf("input expression: %s: %s", err, e.raw)
 	}
-	return &typedInputExpr{input}, nil
+	return &typedInputExpr{input: input, raw: e.raw}, nil
+}
+
+// EmptyIn specifies how an IN clause with an empty slice, "col IN ($S[:])",
+// is rendered. It is passed to BindTypes alongside the type samples.
+type EmptyIn int
+
+const (
+	// EmptyInError makes BindInputs return an *EmptySliceError.
+	EmptyInError EmptyIn = iota
+	// EmptyInFalse renders "col IN ($S[:])" as false and
+	// "col NOT IN ($S[:])" as true.
+	EmptyInFalse
+	// EmptyInTrue renders "col IN ($S[:])" as true and "col NOT IN ($S[:])"
+	// as false.
+	EmptyInTrue
+)
+
+// LargeSlices specifies how the slice in an IN clause, "col IN ($S[:])", is
+// passed to the database when it has more elements than the database allows
+// query parameters. It is passed to BindTypes alongside the type samples.
+type LargeSlices int
+
+const (
+	// LargeSlicesParams passes every element of the slice as a query
+	// parameter regardless of its length.
+	LargeSlicesParams LargeSlices = iota
+	// LargeSlicesJSONEach passes slices that would take the query over 32766
+	// parameters, the SQLite limit, as a single JSON array parameter
+	// expanded with json_each.
+	LargeSlicesJSONEach
+	// LargeSlicesUnnest passes slices that would take the query over 65535
+	// parameters, the Postgres limit, as a single array parameter expanded
+	// with unnest, or an array for each column of a tuple IN clause.
+	LargeSlicesUnnest
+)
+
+// NullResults specifies how NULL results are read into outputs that cannot be
+// set to nil, such as string struct fields. It is passed to BindTypes
+// alongside the type samples.
+type NullResults int
+
+const (
+	// NullResultsZero sets the outputs to their zero value.
+	NullResultsZero NullResults = iota
+	// NullResultsError makes ScanPlan.OnSuccess return a
+	// *typeinfo.NullError naming the column, unless the output has a default
+	// value.
+	NullResultsError
+)
+
+// maxParams returns the number of query parameters the database allows. A
+// slice that would take a query over the limit is passed as a single
+// parameter, or a single parameter for each column of a tuple IN clause.
+func (ls LargeSlices) maxParams() int {
+	switch ls {
+	case LargeSlicesJSONEach:
+		return 32766
+	case LargeSlicesUnnest:
+		return 65535
+	}
+	return math.MaxInt
+}
+
+// inExpr is an IN clause of the form "col IN ($S[:])" where the slice is the
+// only value in the clause.
+type inExpr struct {
+	raw string
+	// prefix is the SQL from the start of the clause up to the slice, e.g.
+	// "col IN (".
+	prefix string
+	// suffix is the SQL after the slice up to the end of the clause, e.g. ")".
+	suffix  string
+	operand string
+	// columns are the names of the columns in the operand of the clause.
+	columns []string
+	not     bool
+	slice   *sliceInputExpr
+}
+
+// String returns a text representation for debugging and testing purposes.
+func (e *inExpr) String() string {
+	if e.not {
+		return fmt.Sprintf("NotIn[%s %s]", e.operand, e.slice.String())
+	}
+	return fmt.Sprintf("In[%s %s]", e.operand, e.slice.String())
+}
+
+// bindTypes generates a *typedInExpr containing type information about the
+// slice. If the slice elements are structs, the fields that match the columns
+// of the operand are passed as parameters.
+func (e *inExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
+	input, err := argInfo.InputSliceColumns(e.slice.sliceTypeName, e.columns)
+	if err != nil {
+		return nil, fmt.Errorf("input expression: %s: %s", err, e.slice.raw)
+	}
+	return &typedInExpr{input: input, tupleSize: len(e.columns), prefix: e.prefix, suffix: e.suffix, not: e.not, raw: e.raw}, nil
+}
+
+// predicateInputExpr is an input expression of the form "$Type.*" which
+// represents an equality predicate on each non-zero field of a struct.
+type predicateInputExpr struct {
+	raw      string
+	typeName string
+}
+
+// String returns a text representation for debugging and testing purposes.
+func (e *predicateInputExpr) String() string {
+	return fmt.Sprintf("Predicate[%s.*]", e.typeName)
+}
+
+// bindTypes generates a *typedPredicateInputExpr containing type information
+// about every tagged field of the struct.
+func (e *predicateInputExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
+	inputs, columns, err := argInfo.AllStructInputs(e.typeName)
+	if err != nil {
+		return nil, fmt.Errorf("input expression: %s: %s", err, e.raw)
+	}
+	return &typedPredicateInputExpr{inputs: inputs, columns: columns, raw: e.raw}, nil
+}
+
+// positionalInputExpr is an input expression of the form "$N" which
+// represents a query parameter passed as the Nth positional input argument.
+type positionalInputExpr struct {
+	raw      string
+	position int
+}
+
+// String returns a text representation for debugging and testing purposes.
+func (e *positionalInputExpr) String() string {
+	return fmt.Sprintf("Input[%d]", e.position)
+}
+
+// bindTypes generates a *typedPositionalInputExpr. Positional arguments are
+// not typed so there is no type information to check.
+func (e *positionalInputExpr) bindTypes(typeinfo.ArgInfo) (any, error) {
+	return &typedPositionalInputExpr{position: e.position, raw: e.raw}, nil
+}
+
+// IsOption reports whether an argument to BindTypes is a statement option,
+// such as Identifiers, rather than a type sample.
+func IsOption(arg any) bool {
+	switch arg.(type) {
+	case Identifiers, EmptyIn, LargeSlices, typeinfo.Dialect, NullResults, *typeinfo.Mapper:
+		return true
+	}
+	return false
+}
+
+// Identifiers is a list of identifiers that may be inserted into the query by
+// identifier input expressions. It is passed to BindTypes alongside the type
+// samples.
+type Identifiers []string
+
+// identifierInputExpr is an input expression of the form "#Type.member" which
+// represents an identifier, such as a column name, contained in a member of
+// a type.
+type identifierInputExpr struct {
+	raw string
+	ma  memberAccessor
+}
+
+// String returns a text representation for debugging and testing purposes.
+func (e *identifierInputExpr) String() string {
+	return fmt.Sprintf("Identifier[%+v]", e.ma)
+}
+
+// bindTypes generates a *typedIdentifierInputExpr containing type information
+// about the Go object and its member.
+func (e *identifierInputExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
+	input, err := argInfo.InputMember(e.ma.typeName, e.ma.memberName)
+	if err != nil {
+		return nil, fmt.Errorf("input expression: %s: %s", err, e.raw)
+	}
+	return &typedIdentifierInputExpr{input: input, raw: e.raw}, nil
+}
+
+// conditionalExpr is a section of the query of the form "{{ ... }}" that is
+// only included if all the input expressions within it have values.
+type conditionalExpr struct {
+	raw   string
+	exprs []expression
+}
+
+// String returns a text representation for debugging and testing purposes.
+func (e *conditionalExpr) String() string {
+	var out bytes.Buffer
+	out.WriteString("Conditional[")
+	for i, ne := range e.exprs {
+		if i > 0 {
+			out.WriteString(" ")
+		}
+		out.WriteString(ne.String())
+	}
+	out.WriteString("]")
+	return out.String()
+}
+
+// bindTypes generates a *typedConditionalExpr containing the nested
+// expressions bound to their types.
+func (e *conditionalExpr) bindTypes(argInfo typeinfo.ArgInfo) (any, error) {
+	var exprs []any
+	for _, ne := range e.exprs {
+		te, err := ne.bindTypes(argInfo)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, te)
+	}
+	return &typedConditionalExpr{exprs: exprs, raw: e.raw}, nil
 }
 
 // outputExpr represents columns to be read from the database and Go values to
@@ -179,7 +456,31 @@ func (e *outputExpr) bindTypes(argInfo typeinfo.ArgInfo) (te any, err error) {
 		}
 
 		for _, t := range e.targetTypes {
+			if t.isAnonymous() {
+				return nil, fmt.Errorf("cannot use anonymous output without an explicit column")
+			}
 			if t.memberName == "*" {
+				kind, err := argInfo.Kind(t.typeName)
+				if err != nil {
+					return nil, err
+				}
+				if kind == reflect.Map {
+					// Read all columns of the results into the map, e.g.
+					// "t.* AS &M.*".
+					if numTypes > 1 {
+						return nil, fmt.Errorf("cannot use map with asterisk alongside other types")
+					}
+					output, err := argInfo.AllColumnsOutput(t.typeName)
+					if err != nil {
+						return nil, err
+					}
+					column := "*"
+					if numColumns > 0 {
+						column = e.sourceColumns[0].String()
+					}
+					return &typedColumnsOutputExpr{column: column, output: output}, nil
+				}
+
 				// Generate asterisk columns.
 				outputs, memberNames, err := argInfo.AllStructOutputs(t.typeName)
 				if err != nil {
@@ -223,6 +524,10 @@ func (e *outputExpr) bindTypes(argInfo typeinfo.ArgInfo) (te any, err error) {
 	if numColumns == numTypes {
 		for i, c := range e.sourceColumns {
 			t := e.targetTypes[i]
+			if t.isAnonymous() {
+				toe.outputColumns = append(toe.outputColumns, newOutputColumn(c.tableName(), c.columnName(), nil))
+				continue
+			}
 			output, err := argInfo.OutputMember(t.typeName, t.memberName)
 			if err != nil {
 				return nil, err
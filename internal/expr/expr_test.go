@@ -51,6 +51,10 @@ type IntSlice []int
 
 type StringSlice []string
 
+type AddressSlice []Address
+
+type PersonPtrSlice []*Person
+
 var tests = []struct {
 	summary        string
 	query          string
@@ -252,8 +256,8 @@ AND z = @sqlair_0 -- The line with $Person.id on it
 	expectedParsed: "[Bypass[SELECT ] Output[[p.*] [Person.*]] Bypass[ FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = ] Input[Person.name] Bypass[) UNION SELECT ] Output[[a.district a.street] [Address.*]] Bypass[ FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = ] Input[Person.name] Bypass[)]]",
 	typeSamples:    []any{Person{}, Address{}},
 	inputArgs:      []any{Person{Fullname: "Foo"}},
-	expectedParams: []any{"Foo", "Foo"},
-	expectedSQL:    `SELECT p.address_id AS _sqlair_0, p.id AS _sqlair_1, p.name AS _sqlair_2 FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = @sqlair_0) UNION SELECT a.district AS _sqlair_3, a.street AS _sqlair_4 FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = @sqlair_1)`,
+	expectedParams: []any{"Foo"},
+	expectedSQL:    `SELECT p.address_id AS _sqlair_0, p.id AS _sqlair_1, p.name AS _sqlair_2 FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = @sqlair_0) UNION SELECT a.district AS _sqlair_3, a.street AS _sqlair_4 FROM person WHERE p.name IN (SELECT name FROM table WHERE table.n = @sqlair_0)`,
 }, {
 	summary:        "complex query v5",
 	query:          "SELECT p.* AS &Person.* FROM person AS p JOIN address AS a ON p.address_id = a.id WHERE p.name = $Person.name AND p.address_id = $Person.address_id",
@@ -330,9 +334,9 @@ AND z = @sqlair_0 -- The line with $Person.id on it
 	(] Input[HardMaths.coef] Bypass[%] Input[HardMaths.x] Bypass[)-] Input[HardMaths.y] Bypass[|] Input[HardMaths.z] Bypass[<] Input[HardMaths.z] Bypass[<>] Input[HardMaths.x]]`,
 	typeSamples:    []any{HardMaths{}},
 	inputArgs:      []any{HardMaths{X: 1, Y: 2, Z: 3, Coef: 4}},
-	expectedParams: []any{1, 2, 3, 4, 1, 2, 3, 3, 1},
+	expectedParams: []any{1, 2, 3, 4},
 	expectedSQL: `SELECT name FROM person WHERE id =@sqlair_0+@sqlair_1/@sqlair_2-
-	(@sqlair_3%@sqlair_4)-@sqlair_5|@sqlair_6<@sqlair_7<>@sqlair_8`,
+	(@sqlair_3%@sqlair_0)-@sqlair_1|@sqlair_2<@sqlair_2<>@sqlair_0`,
 }, {
 	summary:        "insert array",
 	query:          "INSERT INTO arr VALUES (ARRAY[[1,2],[$HardMaths.x,4]], ARRAY[[5,6],[$HardMaths.y,8]]);",
@@ -352,7 +356,7 @@ AND z = @sqlair_0 -- The line with $Person.id on it
 }, {
 	summary:        "single slice",
 	query:          "SELECT name FROM person WHERE id IN ($S[:])",
-	expectedParsed: "[Bypass[SELECT name FROM person WHERE id IN (] Input[S[:]] Bypass[)]]",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[id Input[S[:]]]]",
 	typeSamples:    []any{sqlair.S{}},
 	inputArgs:      []any{sqlair.S{1, 2, 3}},
 	expectedParams: []any{1, 2, 3},
@@ -376,33 +380,187 @@ AND z = @sqlair_0 -- The line with $Person.id on it
 }, {
 	summary:        "slice of mixed types",
 	query:          "SELECT name FROM person WHERE id IN ($S[:])",
-	expectedParsed: "[Bypass[SELECT name FROM person WHERE id IN (] Input[S[:]] Bypass[)]]",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[id Input[S[:]]]]",
 	typeSamples:    []any{sqlair.S{}},
 	inputArgs:      []any{sqlair.S{1, "two", 3.0}},
 	expectedParams: []any{1, "two", 3.0},
 	expectedSQL:    "SELECT name FROM person WHERE id IN (@sqlair_0, @sqlair_1, @sqlair_2)",
 }, {
-	// No error is throw for when the user passes an empty slice because we do
-	// not want to limit the use of slices to only the cases we have foreseen.
+	// No error is throw for when the user passes an empty slice outside of an
+	// IN clause because we do not want to limit the use of slices to only the
+	// cases we have foreseen.
 	summary:        "empty slice",
-	query:          "SELECT name FROM person WHERE id IN ($S[:])",
-	expectedParsed: "[Bypass[SELECT name FROM person WHERE id IN (] Input[S[:]] Bypass[)]]",
+	query:          "SELECT name FROM person WHERE id IN ($S[:], 1)",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE id IN (] Input[S[:]] Bypass[, 1)]]",
 	typeSamples:    []any{sqlair.S{}},
 	inputArgs:      []any{sqlair.S{}},
 	expectedParams: []any{},
-	// This is valid in SQLite (though not in MySQL).
-	expectedSQL: "SELECT name FROM person WHERE id IN ()",
+	expectedSQL:    "SELECT name FROM person WHERE id IN (, 1)",
 }, {
-	// The nil slice is used interchangeably with the empty slice so this is
-	// allowed as well.
-	summary:        "nil slice",
-	query:          "SELECT name FROM person WHERE id IN ($S[:])",
-	expectedParsed: "[Bypass[SELECT name FROM person WHERE id IN (] Input[S[:]] Bypass[)]]",
-	typeSamples:    []any{sqlair.S{}},
-	inputArgs:      []any{(sqlair.S)(nil)},
+	summary:        "empty slice in IN clause rendered as false",
+	query:          "SELECT name FROM person WHERE p.id IN ( $S[:] ) OR id NOT IN($IntSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[p.id Input[S[:]]] Bypass[ OR ] NotIn[id Input[IntSlice[:]]]]",
+	typeSamples:    []any{sqlair.S{}, IntSlice{}, sqlair.EmptyInFalse},
+	inputArgs:      []any{sqlair.S{}, IntSlice{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE 1=0 OR 1=1",
+}, {
+	// The nil slice is used interchangeably with the empty slice.
+	summary:        "nil slice in IN clause rendered as true",
+	query:          "SELECT name FROM person WHERE id in ($S[:]) AND id NOT IN ($IntSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[id Input[S[:]]] Bypass[ AND ] NotIn[id Input[IntSlice[:]]]]",
+	typeSamples:    []any{sqlair.S{}, IntSlice{}, sqlair.EmptyInTrue},
+	inputArgs:      []any{(sqlair.S)(nil), IntSlice{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE 1=1 AND 1=0",
+}, {
+	summary:        "empty slices in IN clauses with quoted and function operands",
+	query:          `SELECT name FROM person WHERE "p"."id" IN ($S[:]) OR lower(name) NOT IN ($IntSlice[:]) OR (` + "`a`.`street`" + `, "district") IN ($AddressSlice[:])`,
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[\"p\".\"id\" Input[S[:]]] Bypass[ OR ] NotIn[lower(name) Input[IntSlice[:]]] Bypass[ OR ] In[(`a`.`street`, \"district\") Input[AddressSlice[:]]]]",
+	typeSamples:    []any{sqlair.S{}, IntSlice{}, AddressSlice{}, sqlair.EmptyInFalse},
+	inputArgs:      []any{sqlair.S{}, IntSlice{}, AddressSlice{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE 1=0 OR 1=1 OR 1=0",
+}, {
+	summary:        "non-empty slices in IN clauses",
+	query:          "SELECT name FROM person WHERE p.id IN ( $S[:] ) OR id NOT IN($IntSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[p.id Input[S[:]]] Bypass[ OR ] NotIn[id Input[IntSlice[:]]]]",
+	typeSamples:    []any{sqlair.S{}, IntSlice{}, sqlair.EmptyInFalse},
+	inputArgs:      []any{sqlair.S{1}, IntSlice{2, 3}},
+	expectedParams: []any{1, 2, 3},
+	expectedSQL:    "SELECT name FROM person WHERE p.id IN ( @sqlair_0 ) OR id NOT IN(@sqlair_1, @sqlair_2)",
+}, {
+	summary:        "tuple IN clause with slice of structs",
+	query:          "SELECT name FROM address WHERE (a.street, district) NOT IN ($AddressSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM address WHERE ] NotIn[(a.street, district) Input[AddressSlice[:]]]]",
+	typeSamples:    []any{AddressSlice{}},
+	inputArgs:      []any{AddressSlice{{Street: "Main Street", District: "Centre"}, {Street: "High Street", District: "North"}}},
+	expectedParams: []any{"Main Street", "Centre", "High Street", "North"},
+	expectedSQL:    "SELECT name FROM address WHERE (a.street, district) NOT IN ((@sqlair_0, @sqlair_1), (@sqlair_2, @sqlair_3))",
+}, {
+	summary:        "IN clause with slice of struct pointers",
+	query:          "SELECT name FROM person WHERE id IN ($PersonPtrSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] In[id Input[PersonPtrSlice[:]]]]",
+	typeSamples:    []any{PersonPtrSlice{}},
+	inputArgs:      []any{PersonPtrSlice{{ID: 1}, {ID: 2}}},
+	expectedParams: []any{1, 2},
+	expectedSQL:    "SELECT name FROM person WHERE id IN (@sqlair_0, @sqlair_1)",
+}, {
+	summary:        "repeated inputs share parameters",
+	query:          "SELECT name FROM person WHERE $Person.* AND name = $Person.name AND id IN ($IntSlice[:]) AND address_id IN ($IntSlice[:]) AND (id, name) IN ($PersonPtrSlice[:]) AND id IN ($PersonPtrSlice[:])",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] Predicate[Person.*] Bypass[ AND name = ] Input[Person.name] Bypass[ AND ] In[id Input[IntSlice[:]]] Bypass[ AND ] In[address_id Input[IntSlice[:]]] Bypass[ AND ] In[(id, name) Input[PersonPtrSlice[:]]] Bypass[ AND ] In[id Input[PersonPtrSlice[:]]]]",
+	typeSamples:    []any{Person{}, IntSlice{}, PersonPtrSlice{}},
+	inputArgs:      []any{Person{Fullname: "Fred"}, IntSlice{1, 2}, PersonPtrSlice{{ID: 3, Fullname: "Mark"}}},
+	expectedParams: []any{"Fred", 1, 2, 3, "Mark", 3},
+	expectedSQL:    "SELECT name FROM person WHERE name = @sqlair_0 AND name = @sqlair_0 AND id IN (@sqlair_1, @sqlair_2) AND address_id IN (@sqlair_1, @sqlair_2) AND (id, name) IN ((@sqlair_3, @sqlair_4)) AND id IN (@sqlair_5)",
+}, {
+	summary:        "all columns into map",
+	query:          "SELECT &M.* FROM person WHERE name = $Person.name",
+	expectedParsed: "[Bypass[SELECT ] Output[[] [M.*]] Bypass[ FROM person WHERE name = ] Input[Person.name]]",
+	typeSamples:    []any{sqlair.M{}, Person{}},
+	inputArgs:      []any{Person{Fullname: "Fred"}},
+	expectedParams: []any{"Fred"},
+	expectedSQL:    "SELECT * FROM person WHERE name = @sqlair_0",
+}, {
+	summary:        "all columns of table into map",
+	query:          "SELECT p.* AS &M.*, a.id AS &Address.id FROM person AS p, address AS a",
+	expectedParsed: "[Bypass[SELECT ] Output[[p.*] [M.*]] Bypass[, ] Output[[a.id] [Address.id]] Bypass[ FROM person AS p, address AS a]]",
+	typeSamples:    []any{sqlair.M{}, Address{}},
+	expectedSQL:    "SELECT p.*, a.id AS _sqlair_0 FROM person AS p, address AS a",
+}, {
+	summary:        "anonymous outputs",
+	query:          "SELECT count(*) AS &_, (name, id) AS (&_, &Person.id) FROM person",
+	expectedParsed: "[Bypass[SELECT ] Output[[count(*)] [_]] Bypass[, ] Output[[name id] [_ Person.id]] Bypass[ FROM person]]",
+	typeSamples:    []any{Person{}},
+	expectedSQL:    "SELECT count(*) AS _sqlair_0, name AS _sqlair_1, id AS _sqlair_2 FROM person",
+}, {
+	summary:        "positional inputs",
+	query:          "SELECT name FROM person WHERE id = $1 AND name = $Person.name OR id IN ($2, $1)",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE id = ] Input[1] Bypass[ AND name = ] Input[Person.name] Bypass[ OR id IN (] Input[2] Bypass[, ] Input[1] Bypass[)]]",
+	typeSamples:    []any{Person{}},
+	inputArgs:      []any{30, Person{Fullname: "Fred"}, "x"},
+	expectedParams: []any{30, "Fred", "x"},
+	expectedSQL:    "SELECT name FROM person WHERE id = @sqlair_0 AND name = @sqlair_1 OR id IN (@sqlair_2, @sqlair_0)",
+}, {
+	summary:        "explicit positional inputs",
+	query:          "SELECT name FROM person WHERE id = $1 AND name = $Person.name",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE id = ] Input[1] Bypass[ AND name = ] Input[Person.name]]",
+	typeSamples:    []any{Person{}},
+	inputArgs:      []any{sqlair.Arg(Person{ID: 5}), Person{Fullname: "Fred"}},
+	expectedParams: []any{Person{ID: 5}, "Fred"},
+	expectedSQL:    "SELECT name FROM person WHERE id = @sqlair_0 AND name = @sqlair_1",
+}, {
+	summary:        "struct predicate",
+	query:          "SELECT name FROM person WHERE $Person.* AND $Address.*",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] Predicate[Person.*] Bypass[ AND ] Predicate[Address.*]]",
+	typeSamples:    []any{Person{}, Address{}},
+	inputArgs:      []any{Person{ID: 30, Fullname: "Fred"}, Address{Street: "Main Street"}},
+	expectedParams: []any{30, "Fred", "Main Street"},
+	expectedSQL:    "SELECT name FROM person WHERE (id = @sqlair_0 AND name = @sqlair_1) AND street = @sqlair_2",
+}, {
+	summary:        "empty struct predicate",
+	query:          "SELECT name FROM person WHERE $Person.*",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE ] Predicate[Person.*]]",
+	typeSamples:    []any{Person{}},
+	inputArgs:      []any{Person{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE 1=1",
+}, {
+	summary:        "identifier inputs",
+	query:          "SELECT name FROM person ORDER BY #M.column, #Person.name # comment #temp",
+	expectedParsed: "[Bypass[SELECT name FROM person ORDER BY ] Identifier[M.column] Bypass[, ] Identifier[Person.name] Bypass[ # comment #temp]]",
+	typeSamples:    []any{Person{}, sqlair.M{}, sqlair.Identifiers("id", "p.name")},
+	inputArgs:      []any{sqlair.M{"column": "id"}, Person{Fullname: "p.name"}},
 	expectedParams: []any{},
-	// This is valid in SQLite (though not in MySQL).
-	expectedSQL: "SELECT name FROM person WHERE id IN ()",
+	expectedSQL:    `SELECT name FROM person ORDER BY "id", "p"."name" # comment #temp`,
+}, {
+	summary:        "identifier input in conditional section",
+	query:          "SELECT name FROM person {{ORDER BY #M.column}}",
+	expectedParsed: "[Bypass[SELECT name FROM person ] Conditional[Bypass[ORDER BY ] Identifier[M.column]]]",
+	typeSamples:    []any{sqlair.M{}, sqlair.Identifiers("id")},
+	inputArgs:      []any{sqlair.M{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person ",
+}, {
+	summary:        "conditional sections included",
+	query:          "SELECT name FROM person WHERE 1=1 {{AND id = $Person.id}} {{ AND team = $M.team }}{{AND id IN ($IntSlice[:])}}",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE 1=1 ] Conditional[Bypass[AND id = ] Input[Person.id]] Bypass[ ] Conditional[Bypass[ AND team = ] Input[M.team] Bypass[ ]] Conditional[Bypass[AND ] In[id Input[IntSlice[:]]]]]",
+	typeSamples:    []any{Person{}, sqlair.M{}, IntSlice{}},
+	inputArgs:      []any{Person{ID: 30}, sqlair.M{"team": "red"}, IntSlice{1, 2}},
+	expectedParams: []any{30, "red", 1, 2},
+	expectedSQL:    "SELECT name FROM person WHERE 1=1 AND id = @sqlair_0  AND team = @sqlair_1 AND id IN (@sqlair_2, @sqlair_3)",
+}, {
+	summary:        "conditional sections dropped",
+	query:          "SELECT name FROM person WHERE 1=1 {{AND id = $Person.id}} {{ AND team = $M.team }}{{AND id IN ($IntSlice[:])}}",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE 1=1 ] Conditional[Bypass[AND id = ] Input[Person.id]] Bypass[ ] Conditional[Bypass[ AND team = ] Input[M.team] Bypass[ ]] Conditional[Bypass[AND ] In[id Input[IntSlice[:]]]]]",
+	typeSamples:    []any{Person{}, sqlair.M{}, IntSlice{}},
+	inputArgs:      []any{Person{}, sqlair.M{}, IntSlice{}},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE 1=1  ",
+}, {
+	summary:        "conditional section with several inputs",
+	query:          "SELECT name FROM person WHERE id > 0{{ AND name = $Person.name AND address_id = $1 }}",
+	expectedParsed: "[Bypass[SELECT name FROM person WHERE id > 0] Conditional[Bypass[ AND name = ] Input[Person.name] Bypass[ AND address_id = ] Input[1] Bypass[ ]]]",
+	typeSamples:    []any{Person{}},
+	inputArgs:      []any{Person{Fullname: "Fred"}, 0},
+	expectedParams: []any{},
+	expectedSQL:    "SELECT name FROM person WHERE id > 0",
+}, {
+	summary:        "package qualified type names",
+	query:          "SELECT &sqlair.M.name FROM person WHERE id = $expr_test.M.id AND id IN ($sqlair.S[:]) AND #expr_test.M.col = 1",
+	expectedParsed: "[Bypass[SELECT ] Output[[] [sqlair.M.name]] Bypass[ FROM person WHERE id = ] Input[expr_test.M.id] Bypass[ AND ] In[id Input[sqlair.S[:]]] Bypass[ AND ] Identifier[expr_test.M.col] Bypass[ = 1]]",
+	typeSamples:    []any{sqlair.M{}, M{}, sqlair.S{}, sqlair.Identifiers("id")},
+	inputArgs:      []any{M{"id": 1, "col": "id"}, sqlair.S{2}},
+	expectedParams: []any{1, 2},
+	expectedSQL:    "SELECT name AS _sqlair_0 FROM person WHERE id = @sqlair_0 AND id IN (@sqlair_1) AND \"id\" = 1",
+}, {
+	summary:        "dollar quoted strings",
+	query:          "CREATE FUNCTION f(int) RETURNS int AS $$ SELECT $1 + 1 $$ LANGUAGE sql; SELECT $body$ $Person.name $body$, $1",
+	expectedParsed: "[Bypass[CREATE FUNCTION f(int) RETURNS int AS $$ SELECT $1 + 1 $$ LANGUAGE sql; SELECT $body$ $Person.name $body$, ] Input[1]]",
+	typeSamples:    []any{},
+	inputArgs:      []any{5},
+	expectedParams: []any{5},
+	expectedSQL:    "CREATE FUNCTION f(int) RETURNS int AS $$ SELECT $1 + 1 $$ LANGUAGE sql; SELECT $body$ $Person.name $body$, @sqlair_0",
 }}
 
 func (s *ExprSuite) TestExprPkg(c *C) {
@@ -517,9 +675,6 @@ comment */ WHERE x = $Address.&d`,
 	}, {
 		query: "SELECT (name, id) AS (&Person.name, &Person.id",
 		err:   `cannot parse expression: column 22: missing closing parentheses`,
-	}, {
-		query: "SELECT (name, id) WHERE id = $Person.*",
-		err:   `cannot parse expression: column 30: asterisk not allowed in input expression "$Person.*"`,
 	}, {
 		query: `SELECT (name, id) AS (&Person.name, /* multiline
 comment */
@@ -529,8 +684,8 @@ comment */
 	}, {
 		query: `SELECT (name, id) WHERE name = 'multiline
 string
-of three lines' AND id = $Person.*`,
-		err: `cannot parse expression: line 3, column 26: asterisk not allowed in input expression "$Person.*"`,
+of three lines' AND id = $0`,
+		err: `cannot parse expression: line 3, column 26: invalid positional input "$0", positions start at 1`,
 	}, {
 		query: "SELECT &S[:] FROM t",
 		err:   `cannot parse expression: column 8: cannot use slice syntax "S[:]" in output expression`,
@@ -564,9 +719,27 @@ of three lines' AND id = $Person.*`,
 	}, {
 		query: "SELECT * FROM t WHERE id = $ids[]",
 		err:   `cannot parse expression: column 29: invalid slice: expected 'ids[:]'`,
+	}, {
+		query: "SELECT * FROM t WHERE id = $0",
+		err:   `cannot parse expression: column 28: invalid positional input "$0", positions start at 1`,
 	}, {
 		query: "SELECT count(*) AS &M.* FROM t",
 		err:   `cannot parse expression: column 8: cannot read function call "count(*)" into asterisk`,
+	}, {
+		query: "SELECT * FROM t WHERE 1=1 {{ AND id = $Person.id",
+		err:   `cannot parse expression: column 27: missing closing "}}" of conditional section`,
+	}, {
+		query: "SELECT * FROM t WHERE 1=1 {{ AND id = 1 }}",
+		err:   `cannot parse expression: column 27: no input expressions in conditional section`,
+	}, {
+		query: "SELECT * FROM t WHERE 1=1 {{ AND {{ id = $Person.id }} }}",
+		err:   `cannot parse expression: column 34: cannot nest conditional sections`,
+	}, {
+		query: "SELECT * FROM t WHERE 1=1 {{ AND id = $Person.id, name AS &Person.name }}",
+		err:   `cannot parse expression: column 51: cannot use output expression in conditional section`,
+	}, {
+		query: "SELECT * FROM t WHERE name = '{{' {{ AND id = $Person.id -- }}",
+		err:   `cannot parse expression: column 35: missing closing "}}" of conditional section`,
 	}, {
 		query: "SELECT (id, count(*)) AS (&M.*) FROM t",
 		err:   `cannot parse expression: column 8: cannot read function call "count(*)" into asterisk`,
@@ -606,6 +779,18 @@ func (s *ExprSuite) TestBindTypesErrors(c *C) {
 		typeSamples []any
 		err         string
 	}{{
+		query:       "SELECT name FROM t WHERE (id, name) IN ($IntSlice[:])",
+		typeSamples: []any{IntSlice{}},
+		err:         "cannot prepare statement: input expression: cannot compare 2 columns with slice of int: $IntSlice[:]",
+	}, {
+		query:       "SELECT name FROM t WHERE (id, name) IN ($AddressSlice[:])",
+		typeSamples: []any{AddressSlice{}},
+		err:         `cannot prepare statement: input expression: type "Address" has no "name" db tag: $AddressSlice[:]`,
+	}, {
+		query:       "SELECT name FROM t ORDER BY #Address.street",
+		typeSamples: []any{Address{}},
+		err:         "cannot prepare statement: input expression: no identifiers allowed, use sqlair.Identifiers: #Address.street",
+	}, {
 		query:       "SELECT (p.name, t.id) AS (&Address.id) FROM t",
 		typeSamples: []any{Address{}},
 		err:         "cannot prepare statement: output expression: mismatched number of columns and target types: (p.name, t.id) AS (&Address.id)",
@@ -717,6 +902,22 @@ func (s *ExprSuite) TestBindTypesErrors(c *C) {
 		query:       "SELECT &S.one FROM person",
 		typeSamples: []any{sqlair.S{}},
 		err:         `cannot prepare statement: output expression: cannot get named member of slice: &S.one`,
+	}, {
+		query:       "SELECT name FROM person WHERE $M.*",
+		typeSamples: []any{sqlair.M{}},
+		err:         `cannot prepare statement: input expression: cannot use map with asterisk in input expression: $M.*`,
+	}, {
+		query:       "SELECT name FROM person WHERE $NoTags.*",
+		typeSamples: []any{NoTags{}},
+		err:         `cannot prepare statement: input expression: no "db" tags found in struct "NoTags": $NoTags.*`,
+	}, {
+		query:       "SELECT &_ FROM t",
+		typeSamples: []any{},
+		err:         "cannot prepare statement: output expression: cannot use anonymous output without an explicit column: &_",
+	}, {
+		query:       "SELECT * AS &_ FROM t",
+		typeSamples: []any{},
+		err:         "cannot prepare statement: output expression: cannot use anonymous output without an explicit column: * AS &_",
 	}, {
 		query:       "SELECT street FROM t WHERE x IN ($int[:])",
 		typeSamples: []any{[]int{}},
@@ -753,20 +954,15 @@ func (s *ExprSuite) TestMapError(c *C) {
 		args    []any
 		expect  string
 	}{{
-		"all output into map star",
-		"SELECT &M.* FROM person WHERE name = 'Fred'",
-		[]any{sqlair.M{}},
-		"cannot prepare statement: output expression: cannot use map with asterisk unless columns are specified: &M.*",
+		"all output into map star with other types",
+		"SELECT (p.*) AS (&M.*, &CustomMap.id) FROM person AS p",
+		[]any{sqlair.M{}, CustomMap{}},
+		"cannot prepare statement: output expression: cannot use map with asterisk alongside other types: (p.*) AS (&M.*, &CustomMap.id)",
 	}, {
-		"all output into map star from table star",
-		"SELECT p.* AS &M.* FROM person WHERE name = 'Fred'",
-		[]any{sqlair.M{}},
-		"cannot prepare statement: output expression: cannot use map with asterisk unless columns are specified: p.* AS &M.*",
-	}, {
-		"all output into map star from lone star",
-		"SELECT * AS &CustomMap.* FROM person WHERE name = 'Fred'",
-		[]any{CustomMap{}},
-		"cannot prepare statement: output expression: cannot use map with asterisk unless columns are specified: * AS &CustomMap.*",
+		"all output into two maps",
+		"SELECT p.* AS &M.*, a.* AS &CustomMap.* FROM person AS p, address AS a",
+		[]any{sqlair.M{}, CustomMap{}},
+		"cannot prepare statement: cannot read all columns into more than one map",
 	}, {
 		"invalid map",
 		"SELECT * AS &InvalidMap.* FROM person WHERE name = 'Fred'",
@@ -776,7 +972,7 @@ func (s *ExprSuite) TestMapError(c *C) {
 		"clashing map and struct names",
 		"SELECT * AS &M.* FROM person WHERE name = $M.id",
 		[]any{M{}, sqlair.M{}},
-		`cannot prepare statement: two types found with name "M": "expr_test.M" and "sqlair.M"`,
+		`cannot prepare statement: output expression: type name "M" is ambiguous, qualify it with its package (have "expr_test.M", "sqlair.M"): * AS &M.*`,
 	},
 	}
 	for _, test := range tests {
@@ -834,6 +1030,21 @@ func (s *ExprSuite) TestBindInputsError(c *C) {
 		typeSamples: []any{Person{}, sqlair.M{}},
 		inputArgs:   []any{Person{ID: 666}, sqlair.M{"Street": "Highway to Hell"}},
 		err:         `invalid input parameter: map "M" does not contain key "street"`,
+	}, {
+		query:       "SELECT street FROM t WHERE 1=1 {{ AND x = $Address.street }}",
+		typeSamples: []any{Address{}},
+		inputArgs:   []any{},
+		err:         `invalid input parameter: parameter with type "Address" missing`,
+	}, {
+		query:       "SELECT street FROM t ORDER BY #Address.street",
+		typeSamples: []any{Address{}, sqlair.Identifiers("street")},
+		inputArgs:   []any{Address{Street: "street; DROP TABLE t"}},
+		err:         `invalid input parameter: tag "street" of struct "Address": identifier "street; DROP TABLE t" not allowed`,
+	}, {
+		query:       "SELECT street FROM t ORDER BY #Address.id",
+		typeSamples: []any{Address{}, sqlair.Identifiers("street")},
+		inputArgs:   []any{Address{ID: 1}},
+		err:         `invalid input parameter: tag "id" of struct "Address": need string identifier, got int`,
 	}, {
 		query:       "SELECT street FROM t WHERE x = $Address.street, y = $Person.name",
 		typeSamples: []any{Address{}, Person{}},
@@ -844,6 +1055,16 @@ func (s *ExprSuite) TestBindInputsError(c *C) {
 		typeSamples: []any{Person{}},
 		inputArgs:   []any{Person{}, Person{}},
 		err:         `invalid input parameter: type "Person" provided more than once`,
+	}, {
+		query:       "SELECT street FROM t WHERE x IN ($S[:])",
+		typeSamples: []any{sqlair.S{}},
+		inputArgs:   []any{sqlair.S{}},
+		err:         `invalid input parameter: empty slice "S" in IN clause`,
+	}, {
+		query:       "SELECT street FROM t WHERE id IN ($PersonPtrSlice[:])",
+		typeSamples: []any{PersonPtrSlice{}},
+		inputArgs:   []any{PersonPtrSlice{nil}},
+		err:         `invalid input parameter: got nil pointer at index 0 of slice "PersonPtrSlice"`,
 	}, {
 		query:       "SELECT street FROM t WHERE x IN ($S[:])",
 		typeSamples: []any{sqlair.S{}},
@@ -854,6 +1075,21 @@ func (s *ExprSuite) TestBindInputsError(c *C) {
 		typeSamples: []any{sqlair.M{}},
 		inputArgs:   []any{(sqlair.M)(nil)},
 		err:         `invalid input parameter: got nil M`,
+	}, {
+		query:       "SELECT street FROM t WHERE x = $1 AND y = $3",
+		typeSamples: []any{},
+		inputArgs:   []any{1, 2},
+		err:         `invalid input parameter: positional argument $3 missing (have 2)`,
+	}, {
+		query:       "SELECT street FROM t WHERE x = $1",
+		typeSamples: []any{},
+		inputArgs:   []any{1, 2},
+		err:         `invalid input parameter: positional argument $2 not referenced in query`,
+	}, {
+		query:       "SELECT street FROM t WHERE x = $Address.street",
+		typeSamples: []any{Address{}},
+		inputArgs:   []any{Address{}, sqlair.Arg(1)},
+		err:         `invalid input parameter: positional argument $1 not referenced in query`,
 	}}
 
 	outerP := Person{}
@@ -896,3 +1132,198 @@ func (s *ExprSuite) TestBindInputsError(c *C) {
 		}
 	}
 }
+
+func (s *ExprSuite) TestLargeSlices(c *C) {
+	parser := expr.NewParser()
+	parsedExpr, err := parser.Parse("SELECT name FROM person WHERE id IN ($IntSlice[:])")
+	c.Assert(err, IsNil)
+
+	large := make(IntSlice, 70000)
+	for i := range large {
+		large[i] = i
+	}
+
+	// Without an option every element is a parameter.
+	typedExpr, err := parsedExpr.BindTypes(IntSlice{})
+	c.Assert(err, IsNil)
+	primedQuery, err := typedExpr.BindInputs(large)
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 70000)
+
+	// The dialect only affects array fields, not large slices.
+	typedExpr, err = parsedExpr.BindTypes(IntSlice{}, sqlair.DialectPostgres)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(large)
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 70000)
+
+	// Slices under the limit are unchanged.
+	typedExpr, err = parsedExpr.BindTypes(IntSlice{}, sqlair.SQLiteLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(IntSlice{1, 2})
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM person WHERE id IN (@sqlair_0, @sqlair_1)")
+
+	primedQuery, err = typedExpr.BindInputs(large[:40000])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM person WHERE id IN (SELECT value FROM json_each(@sqlair_0))")
+	c.Assert(primedQuery.Params(), HasLen, 1)
+	js := primedQuery.Params()[0].(sql.NamedArg).Value.(string)
+	c.Assert(js[:10], Equals, "[0,1,2,3,4")
+
+	typedExpr, err = parsedExpr.BindTypes(IntSlice{}, sqlair.PostgresLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(large[:40000])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 40000)
+
+	primedQuery, err = typedExpr.BindInputs(large)
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM person WHERE id IN (SELECT unnest(@sqlair_0))")
+	c.Assert(primedQuery.Params(), HasLen, 1)
+	c.Assert(primedQuery.Params()[0].(sql.NamedArg).Value, DeepEquals, []int(large))
+
+	// The parameters already in the query count towards the limit.
+	parsedExpr, err = parser.Parse("SELECT name FROM person WHERE name = $Person.name AND id IN ($IntSlice[:])")
+	c.Assert(err, IsNil)
+	typedExpr, err = parsedExpr.BindTypes(Person{}, IntSlice{}, sqlair.SQLiteLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(Person{Fullname: "Fred"}, large[:32766])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM person WHERE name = @sqlair_0 AND id IN (SELECT value FROM json_each(@sqlair_1))")
+	primedQuery, err = typedExpr.BindInputs(Person{Fullname: "Fred"}, large[:32765])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 32766)
+
+	// So do the parameters after the IN clause.
+	parsedExpr, err = parser.Parse("SELECT name FROM person WHERE id IN ($IntSlice[:]) AND name = $Person.name")
+	c.Assert(err, IsNil)
+	typedExpr, err = parsedExpr.BindTypes(Person{}, IntSlice{}, sqlair.SQLiteLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(Person{Fullname: "Fred"}, large[:32766])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM person WHERE id IN (SELECT value FROM json_each(@sqlair_0)) AND name = @sqlair_1")
+	primedQuery, err = typedExpr.BindInputs(Person{Fullname: "Fred"}, large[:32765])
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 32766)
+
+	// Only as many slices as needed to bring the query under the limit are
+	// passed as single parameters.
+	type AddressIDs []int
+	parsedExpr, err = parser.Parse("SELECT name FROM person WHERE id IN ($IntSlice[:]) AND address_id IN ($AddressIDs[:])")
+	c.Assert(err, IsNil)
+	typedExpr, err = parsedExpr.BindTypes(IntSlice{}, AddressIDs{}, sqlair.SQLiteLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(large[:30000], AddressIDs(large[:30000]))
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.Params(), HasLen, 30001)
+	c.Assert(primedQuery.SQL(), Matches, `SELECT name FROM person WHERE id IN \(SELECT value FROM json_each\(@sqlair_0\)\) AND address_id IN \(@sqlair_1, .*\)`)
+
+	// Large tuple IN clauses are passed as rows.
+	addresses := make(AddressSlice, 20000)
+	for i := range addresses {
+		addresses[i] = Address{ID: i, Street: "Main Street"}
+	}
+	parsedExpr, err = parser.Parse("SELECT name FROM address WHERE (id, street) IN ($AddressSlice[:])")
+	c.Assert(err, IsNil)
+	typedExpr, err = parsedExpr.BindTypes(AddressSlice{}, sqlair.SQLiteLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(addresses)
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM address WHERE (id, street) IN (SELECT json_extract(value, '$[0]'), json_extract(value, '$[1]') FROM json_each(@sqlair_0))")
+	js = primedQuery.Params()[0].(sql.NamedArg).Value.(string)
+	c.Assert(js[:35], Equals, `[[0,"Main Street"],[1,"Main Street"`)
+
+	typedExpr, err = parsedExpr.BindTypes(AddressSlice{}, sqlair.PostgresLargeSlices)
+	c.Assert(err, IsNil)
+	primedQuery, err = typedExpr.BindInputs(append(addresses, addresses[:20000]...))
+	c.Assert(err, IsNil)
+	c.Assert(primedQuery.SQL(), Equals, "SELECT name FROM address WHERE (id, street) IN (SELECT * FROM unnest(@sqlair_0, @sqlair_1))")
+	c.Assert(primedQuery.Params(), HasLen, 2)
+	c.Assert(primedQuery.Params()[0].(sql.NamedArg).Value, HasLen, 40000)
+	c.Assert(primedQuery.Params()[1].(sql.NamedArg).Value.([]string)[0], Equals, "Main Street")
+}
+
+func (s *ExprSuite) TestRebindInputs(c *C) {
+	parser := expr.NewParser()
+	parsedExpr, err := parser.Parse("SELECT &Person.* FROM person WHERE id IN ($IntSlice[:]) {{ AND name = $Person.name }}")
+	c.Assert(err, IsNil)
+	typedExpr, err := parsedExpr.BindTypes(Person{}, IntSlice{})
+	c.Assert(err, IsNil)
+
+	primedQuery, err := typedExpr.BindInputs(IntSlice{1, 2}, Person{Fullname: "Fred"})
+	c.Assert(err, IsNil)
+	query := primedQuery.SQL()
+	c.Assert(query, Equals, "SELECT address_id AS _sqlair_0, id AS _sqlair_1, name AS _sqlair_2 FROM person WHERE id IN (@sqlair_0, @sqlair_1)  AND name = @sqlair_2 ")
+
+	// Inputs with the same shape only refresh the parameters.
+	rebound, err := typedExpr.RebindInputs(primedQuery, IntSlice{3, 4}, Person{Fullname: "Mark"})
+	c.Assert(err, IsNil)
+	c.Assert(rebound.SQL(), Equals, query)
+	c.Assert(rebound.Params(), DeepEquals, []any{
+		sql.Named("sqlair_0", 3), sql.Named("sqlair_1", 4), sql.Named("sqlair_2", "Mark"),
+	})
+	c.Assert(primedQuery.Params(), DeepEquals, []any{
+		sql.Named("sqlair_0", 1), sql.Named("sqlair_1", 2), sql.Named("sqlair_2", "Fred"),
+	})
+
+	// Inputs with a different shape generate new SQL.
+	rebound, err = typedExpr.RebindInputs(primedQuery, IntSlice{5}, Person{Fullname: "Mary"})
+	c.Assert(err, IsNil)
+	c.Assert(rebound.SQL(), Equals, "SELECT address_id AS _sqlair_0, id AS _sqlair_1, name AS _sqlair_2 FROM person WHERE id IN (@sqlair_0)  AND name = @sqlair_1 ")
+	rebound, err = typedExpr.RebindInputs(primedQuery, IntSlice{5, 6}, Person{})
+	c.Assert(err, IsNil)
+	c.Assert(rebound.SQL(), Equals, "SELECT address_id AS _sqlair_0, id AS _sqlair_1, name AS _sqlair_2 FROM person WHERE id IN (@sqlair_0, @sqlair_1) ")
+
+	_, err = typedExpr.RebindInputs(primedQuery, IntSlice{1, 2})
+	c.Assert(err, ErrorMatches, `invalid input parameter: parameter with type "Person" missing \(have "IntSlice"\)`)
+}
+
+func (s *ExprSuite) TestSplit(c *C) {
+	tests := []struct {
+		script   string
+		expected []string
+	}{{
+		script:   "SELECT 1",
+		expected: []string{"SELECT 1"},
+	}, {
+		script:   "CREATE TABLE t (a text); INSERT INTO t VALUES ($M.a);\n",
+		expected: []string{"CREATE TABLE t (a text)", "INSERT INTO t VALUES ($M.a)"},
+	}, {
+		script:   `INSERT INTO t VALUES ('a;b', "c;""d"); SELECT 2`,
+		expected: []string{`INSERT INTO t VALUES ('a;b', "c;""d")`, "SELECT 2"},
+	}, {
+		script:   "SELECT 1 -- first; still a comment\n; /* second; */ SELECT 2;; -- trailing;",
+		expected: []string{"SELECT 1 -- first; still a comment", "/* second; */ SELECT 2"},
+	}, {
+		script:   " ; -- nothing\n ;",
+		expected: nil,
+	}, {
+		script: `CREATE TRIGGER t_insert AFTER INSERT ON t BEGIN
+	UPDATE s SET n = n + 1;
+	UPDATE s SET m = CASE WHEN new.a = 'x' THEN 1 ELSE 2 END;
+END; INSERT INTO t VALUES ($M.a)`,
+		expected: []string{`CREATE TRIGGER t_insert AFTER INSERT ON t BEGIN
+	UPDATE s SET n = n + 1;
+	UPDATE s SET m = CASE WHEN new.a = 'x' THEN 1 ELSE 2 END;
+END`, "INSERT INTO t VALUES ($M.a)"},
+	}, {
+		script:   "create temp trigger tr before delete on t begin select 1; end; BEGIN; SELECT CASE WHEN 1 THEN 2 END; END",
+		expected: []string{"create temp trigger tr before delete on t begin select 1; end", "BEGIN", "SELECT CASE WHEN 1 THEN 2 END", "END"},
+	}, {
+		script:   "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql; DO $body$ BEGIN PERFORM 1; END $body$; SELECT $1",
+		expected: []string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", "DO $body$ BEGIN PERFORM 1; END $body$", "SELECT $1"},
+	}}
+	for _, t := range tests {
+		parser := expr.NewParser()
+		stmts, err := parser.Split(t.script)
+		c.Assert(err, IsNil, Commentf("script: %s", t.script))
+		c.Assert(stmts, DeepEquals, t.expected, Commentf("script: %s", t.script))
+	}
+
+	parser := expr.NewParser()
+	_, err := parser.Split("SELECT 1; SELECT 'a;")
+	c.Assert(err, ErrorMatches, "column 18: missing closing quote in string literal")
+	_, err = parser.Split("SELECT 1; DO $$ BEGIN")
+	c.Assert(err, ErrorMatches, `column 14: missing closing \$\$ in dollar quoted string`)
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package expr
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/canonical/sqlair/internal/typeinfo"
+)
+
+// ArgMember describes a member of an input or output argument referenced in
+// a query.
+type ArgMember struct {
+	// TypeName is the name of the argument type qualified by its package,
+	// e.g. "state.Model", as it can be written in a query. It is empty for
+	// anonymous outputs.
+	TypeName string
+	// Member is the name of the struct field or map key. It is empty for the
+	// elements of a slice and for anonymous outputs, and "*" for a map that
+	// stores every column of the results.
+	Member string
+	// Type is the Go type of the member. It is nil for anonymous outputs,
+	// whose type is only known when the query is run.
+	Type reflect.Type
+	// Column is the column of the member. For outputs it is the column
+	// fetched from the database, as written in the query.
+	Column string
+}
+
+// typedExprs returns the typed expressions of the query, with the
+// expressions of conditional sections in place of the sections.
+func (tbe *TypeBoundExpr) typedExprs() []any {
+	var typedExprs []any
+	for _, te := range *tbe {
+		if ce, ok := te.(*typedConditionalExpr); ok {
+			typedExprs = append(typedExprs, ce.exprs...)
+		} else {
+			typedExprs = append(typedExprs, te)
+		}
+	}
+	return typedExprs
+}
+
+// Inputs returns the members of the input arguments referenced in the query,
+// in order. Positional inputs are not included.
+func (tbe *TypeBoundExpr) Inputs() []ArgMember {
+	var inputs []ArgMember
+	for _, te := range tbe.typedExprs() {
+		switch te := te.(type) {
+		case *typedInputExpr:
+			inputs = append(inputs, argMembers(te.input)...)
+		case *typedInExpr:
+			inputs = append(inputs, argMembers(te.input)...)
+		case *typedIdentifierInputExpr:
+			inputs = append(inputs, argMembers(te.input)...)
+		case *typedPredicateInputExpr:
+			for i, input := range te.inputs {
+				members := argMembers(input)
+				members[0].Column = te.columns[i]
+				inputs = append(inputs, members...)
+			}
+		}
+	}
+	return inputs
+}
+
+// Outputs returns the members of the output arguments that the query results
+// are read into, in order.
+func (tbe *TypeBoundExpr) Outputs() []ArgMember {
+	var outputs []ArgMember
+	for _, te := range tbe.typedExprs() {
+		switch te := te.(type) {
+		case *typedOutputExpr:
+			for _, oc := range te.outputColumns {
+				member := ArgMember{Column: oc.column}
+				if oc.output != nil {
+					member = argMembers(oc.output)[0]
+					member.Column = oc.column
+				}
+				outputs = append(outputs, member)
+			}
+		case *typedColumnsOutputExpr:
+			member := argMembers(te.output)[0]
+			member.Column = te.column
+			outputs = append(outputs, member)
+		}
+	}
+	return outputs
+}
+
+// Types returns the types of the input and output arguments referenced in
+// the query, in the order they first appear.
+func (tbe *TypeBoundExpr) Types() []reflect.Type {
+	var types []reflect.Type
+	seen := map[reflect.Type]bool{}
+	add := func(vl typeinfo.ValueLocator) {
+		if t := vl.ArgType(); !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	for _, te := range tbe.typedExprs() {
+		switch te := te.(type) {
+		case *typedInputExpr:
+			add(te.input)
+		case *typedInExpr:
+			add(te.input)
+		case *typedIdentifierInputExpr:
+			add(te.input)
+		case *typedPredicateInputExpr:
+			for _, input := range te.inputs {
+				add(input)
+			}
+		case *typedOutputExpr:
+			for _, oc := range te.outputColumns {
+				if oc.output != nil {
+					add(oc.output)
+				}
+			}
+		case *typedColumnsOutputExpr:
+			add(te.output)
+		}
+	}
+	return types
+}
+
+// SQLTemplate returns the SQL of the query with the output expressions
+// expanded into the columns they fetch. Input expressions and conditional
+// sections are left as they are written in the query, since the SQL they
+// generate depends on the input arguments.
+func (tbe *TypeBoundExpr) SQLTemplate() string {
+	var sql bytes.Buffer
+	outputCount := 0
+	for _, te := range *tbe {
+		switch te := te.(type) {
+		case *typedInputExpr:
+			sql.WriteString(te.raw)
+		case *typedInExpr:
+			sql.WriteString(te.raw)
+		case *typedPredicateInputExpr:
+			sql.WriteString(te.raw)
+		case *typedPositionalInputExpr:
+			sql.WriteString(te.raw)
+		case *typedIdentifierInputExpr:
+			sql.WriteString(te.raw)
+		case *typedConditionalExpr:
+			sql.WriteString(te.raw)
+		case *typedOutputExpr:
+			for i, oc := range te.outputColumns {
+				if i != 0 {
+					sql.WriteString(", ")
+				}
+				sql.WriteString(oc.sql(outputCount))
+				outputCount++
+			}
+		case *typedColumnsOutputExpr:
+			sql.WriteString(te.column)
+		case *bypass:
+			sql.WriteString(te.chunk)
+		}
+	}
+	return sql.String()
+}
+
+// argMembers returns the members located by vl, with the package qualified
+// name of its type.
+func argMembers(vl typeinfo.ValueLocator) []ArgMember {
+	var members []ArgMember
+	for _, m := range typeinfo.Members(vl) {
+		members = append(members, ArgMember{
+			TypeName: typeinfo.QualifiedName(vl.ArgType()),
+			Member:   m.Name,
+			Type:     m.Type,
+			Column:   m.Column,
+		})
+	}
+	return members
+}
@@ -5,6 +5,8 @@ package expr
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -27,6 +29,9 @@ type Parser struct {
 	// lineStart is the position of the first byte of the current line in the
 	// input.
 	lineStart int
+	// inConditional is true while parsing the contents of a conditional
+	// section.
+	inConditional bool
 }
 
 // Parse takes an SQLair query string and returns a ParsedExpr.
@@ -51,17 +56,17 @@ func (p *Parser) Parse(input string) (pe *ParsedExpr, err error) {
 			break
 		}
 
-		if out, ok, err := p.parseOutputExpr(); err != nil {
+		if ce, ok, err := p.parseConditionalExpr(); err != nil {
 			return nil, err
 		} else if ok {
-			p.add(out)
+			p.add(ce)
 			continue
 		}
 
-		if in, ok, err := p.parseInputExpr(); err != nil {
+		if e, ok, err := p.parseExpr(); err != nil {
 			return nil, err
 		} else if ok {
-			p.add(in)
+			p.add(e)
 			continue
 		}
 	}
@@ -71,6 +76,26 @@ func (p *Parser) Parse(input string) (pe *ParsedExpr, err error) {
 	return &ParsedExpr{exprs: p.exprs}, nil
 }
 
+// parseExpr parses an output or input expression at the current position.
+func (p *Parser) parseExpr() (expression, bool, error) {
+	if out, ok, err := p.parseOutputExpr(); err != nil {
+		return nil, false, err
+	} else if ok {
+		return out, true, nil
+	}
+
+	if in, ok, err := p.parseInputExpr(); err != nil {
+		return nil, false, err
+	} else if ok {
+		return in, true, nil
+	}
+
+	if in, ok := p.parseIdentifierInputExpr(); ok {
+		return in, true, nil
+	}
+	return nil, false, nil
+}
+
 // memberAccessor stores information for accessing a keyed Go value. It consists
 // of a type name and some value within it to be accessed. For example: a field
 // of a struct, or a key of a map.
@@ -79,9 +104,21 @@ type memberAccessor struct {
 }
 
 func (ma memberAccessor) String() string {
+	if ma.isAnonymous() {
+		return ma.typeName
+	}
 	return ma.typeName + "." + ma.memberName
 }
 
+// anonymousTypeName is the type name used in output expressions of the form
+// "&_" which are scanned into plain pointers rather than structs or maps.
+const anonymousTypeName = "_"
+
+// isAnonymous returns true if the memberAccessor is an anonymous output.
+func (ma memberAccessor) isAnonymous() bool {
+	return ma.typeName == anonymousTypeName
+}
+
 func (ma memberAccessor) getTypeName() string {
 	return ma.typeName
 }
@@ -138,6 +175,7 @@ func (p *Parser) init(input string) {
 	p.exprs = []expression{}
 	p.lineNum = 1
 	p.lineStart = 0
+	p.inConditional = false
 }
 
 // colNum calculates the current column number taking into account line breaks.
@@ -265,6 +303,119 @@ func (p *Parser) skipComment() bool {
 	return false
 }
 
+// Split splits a script of several statements at the semicolons that are not
+// inside string literals, comments, Postgres dollar quoted bodies such as
+// "$$ ... $$", or the BEGIN ... END body of a CREATE TRIGGER statement. The
+// statements are returned without the semicolons, and statements containing
+// only whitespace and comments are dropped.
+func (p *Parser) Split(script string) ([]string, error) {
+	p.init(script)
+	var stmts []string
+	start := 0
+	hasContent := false
+	// words holds the first words of the statement, to recognise triggers.
+	var words []string
+	// depth is the number of BEGIN and CASE blocks open in a trigger.
+	depth := 0
+	for p.pos < len(p.input) {
+		if ok, err := p.skipStringLiteral(); err != nil {
+			return nil, err
+		} else if ok {
+			hasContent = true
+			continue
+		}
+		if ok, err := p.skipDollarQuote(); err != nil {
+			return nil, err
+		} else if ok {
+			hasContent = true
+			continue
+		}
+		if p.skipComment() {
+			continue
+		}
+		switch c := p.input[p.pos]; {
+		case c == ';' && depth == 0:
+			if hasContent {
+				stmts = append(stmts, strings.TrimSpace(p.input[start:p.pos]))
+			}
+			p.advanceByte()
+			start = p.pos
+			hasContent = false
+			words = nil
+			continue
+		case isInitialNameByte(c):
+			hasContent = true
+			wordStart := p.pos
+			p.skipName()
+			word := strings.ToUpper(p.input[wordStart:p.pos])
+			if len(words) < 3 {
+				words = append(words, word)
+			}
+			if !isTrigger(words) {
+				continue
+			}
+			switch word {
+			case "BEGIN", "CASE":
+				depth++
+			case "END":
+				if depth > 0 {
+					depth--
+				}
+			}
+			continue
+		case isNameByte(c):
+			// Skip the rest of a number or a name starting with a digit
+			// so that no keyword is found inside it.
+			hasContent = true
+			for p.pos < len(p.input) && isNameByte(p.input[p.pos]) {
+				p.advanceByte()
+			}
+			continue
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			hasContent = true
+		}
+		p.advanceByte()
+	}
+	if hasContent {
+		stmts = append(stmts, strings.TrimSpace(p.input[start:]))
+	}
+	return stmts, nil
+}
+
+// isTrigger reports whether the first words of a statement start a CREATE
+// TRIGGER statement.
+func isTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TEMP" || words[1] == "TEMPORARY" {
+		return len(words) > 2 && words[2] == "TRIGGER"
+	}
+	return words[1] == "TRIGGER"
+}
+
+// dollarQuoteRegexp matches the opening of a Postgres dollar quoted string,
+// "$$" or "$tag$".
+var dollarQuoteRegexp = regexp.MustCompile(`^\$(?:[A-Za-z_]\w*)?\$`)
+
+// skipDollarQuote advances the parser past a Postgres dollar quoted string,
+// such as a function body, and returns true. If the parser is not at the
+// start of one it returns false.
+func (p *Parser) skipDollarQuote() (bool, error) {
+	tag := dollarQuoteRegexp.FindString(p.input[p.pos:])
+	if tag == "" {
+		return false, nil
+	}
+	end := strings.Index(p.input[p.pos+len(tag):], tag)
+	if end == -1 {
+		return false, errorAt(fmt.Errorf("missing closing %s in dollar quoted string", tag), p.lineNum, p.colNum(), p.input)
+	}
+	for n := len(tag) + end + len(tag); n > 0; n-- {
+		p.advanceByte()
+	}
+	return true, nil
+}
+
 // advance increments p.pos until it reaches content that might preceed a token
 // we want to parse.
 func (p *Parser) advance() error {
@@ -276,9 +427,20 @@ loop:
 		} else if ok {
 			continue
 		}
+		// The bodies of Postgres functions are passed as they are.
+		if ok, err := p.skipDollarQuote(); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
 		if ok := p.skipComment(); ok {
 			continue
 		}
+		// Stop at the delimiters of conditional sections.
+		if strings.HasPrefix(p.input[p.pos:], "{{") ||
+			(p.inConditional && strings.HasPrefix(p.input[p.pos:], "}}")) {
+			break loop
+		}
 
 		switch p.input[p.pos] {
 		// If the preceding byte is one of these then we might be at the start
@@ -292,8 +454,14 @@ loop:
 
 	p.skipBlanks()
 
+	// There are no expressions in a dollar quoted string, so the parser is
+	// advanced past it to the next possible expression.
+	if ok, err := p.skipDollarQuote(); err != nil {
+		return err
+	} else if ok {
+		return p.advance()
+	}
 	return nil
-
 }
 
 // skipStringLiteral jumps over single and double quoted sections of input.
@@ -536,18 +704,52 @@ func (p *Parser) parseTargetType() (memberAccessor, bool, error) {
 		} else if err != nil {
 			return memberAccessor{}, false, errorAt(fmt.Errorf("cannot use slice syntax in output expression"), startLine, startCol, p.input)
 		}
+		if ma, ok := p.parseAnonymousType(); ok {
+			return ma, true, nil
+		}
 		return p.parseTypeAndMember()
 	}
 
 	return memberAccessor{}, false, nil
 }
 
+// parseAnonymousType parses the anonymous type "_" used in output
+// expressions of the form "&_".
+func (p *Parser) parseAnonymousType() (memberAccessor, bool) {
+	cp := p.save()
+	if p.skipByte('_') && !(p.pos < len(p.input) && (isNameByte(p.input[p.pos]) || p.input[p.pos] == '.')) {
+		return memberAccessor{typeName: anonymousTypeName}, true
+	}
+	cp.restore()
+	return memberAccessor{}, false
+}
+
+// parseTypeName parses a Go type name, optionally qualified by its package
+// name, e.g. "Type" or "pkg.Type". A qualified name is only parsed if it is
+// followed by one of the bytes in next, so that "Type.member" is not taken
+// for a qualified type name.
+func (p *Parser) parseTypeName(next string) (string, bool) {
+	id, ok := p.parseIdentifier()
+	if !ok {
+		return "", false
+	}
+	cp := p.save()
+	if p.skipByte('.') {
+		if name, ok := p.parseIdentifier(); ok && p.pos < len(p.input) && strings.IndexByte(next, p.input[p.pos]) >= 0 {
+			return id + "." + name, true
+		}
+	}
+	cp.restore()
+	return id, true
+}
+
 // parseSliceAccessor parses a slice accessor. A slice accessor is of the form
-// "SliceType[:]". It returns the parsed slice type name.
+// "SliceType[:]" or "pkg.SliceType[:]". It returns the parsed slice type
+// name.
 func (p *Parser) parseSliceAccessor() (typeName string, ok bool, err error) {
 	cp := p.save()
 
-	id, ok := p.parseIdentifier()
+	id, ok := p.parseTypeName("[")
 	if !ok {
 		return "", false, nil
 	}
@@ -567,13 +769,14 @@ func (p *Parser) parseSliceAccessor() (typeName string, ok bool, err error) {
 }
 
 // parseTypeAndMember parses a Go type name qualified by a tag name (or asterisk)
-// of the form "TypeName.col_name".
+// of the form "TypeName.col_name". The type name can be qualified by its
+// package name, as in "pkg.TypeName.col_name".
 func (p *Parser) parseTypeAndMember() (memberAccessor, bool, error) {
 	cp := p.save()
 
 	// The error points to the skipped & or $.
 	identifierCol := p.colNum() - 1
-	if id, ok := p.parseIdentifier(); ok {
+	if id, ok := p.parseTypeName("."); ok {
 		if !p.skipByte('.') {
 			return memberAccessor{}, false, errorAt(fmt.Errorf("unqualified type, expected %s.* or %s.<db tag> or %s[:]", id, id, id), p.lineNum, identifierCol, p.input)
 		}
@@ -716,6 +919,173 @@ func (p *Parser) parseOutputExpr() (*outputExpr, bool, error) {
 	return nil, false, nil
 }
 
+// parseIdentifierInputExpr parses an identifier input expression of the form
+// "#Type.member" or "#pkg.Type.member". Input that does not have this form, such as a "#" comment or
+// temporary table name, is left to be passed through to the database.
+func (p *Parser) parseIdentifierInputExpr() (*identifierInputExpr, bool) {
+	cp := p.save()
+	if !p.skipByte('#') {
+		return nil, false
+	}
+	if typeName, ok := p.parseTypeName("."); ok && p.skipByte('.') {
+		if memberName, ok := p.parseIdentifier(); ok {
+			return &identifierInputExpr{
+				ma:  memberAccessor{typeName: typeName, memberName: memberName},
+				raw: p.input[cp.pos:p.pos],
+			}, true
+		}
+	}
+	cp.restore()
+	return nil, false
+}
+
+// parseConditionalExpr parses a conditional section of the form
+// "{{ AND col = $Type.member }}". The contents of the section are parsed into
+// nested bypass and input expressions.
+func (p *Parser) parseConditionalExpr() (*conditionalExpr, bool, error) {
+	cp := p.save()
+	if !p.skipString("{{") {
+		return nil, false, nil
+	}
+	p.inConditional = true
+	defer func() { p.inConditional = false }()
+
+	// Parse the contents of the section into a separate list of expressions.
+	outerExprs, outerPrevExprEnd, outerCurrentExprStart := p.exprs, p.prevExprEnd, p.currentExprStart
+	p.exprs = []expression{}
+	p.prevExprEnd = p.pos
+	hasInput := false
+	for {
+		if err := p.advance(); err != nil {
+			return nil, false, err
+		}
+		p.currentExprStart = p.pos
+
+		if p.pos == len(p.input) {
+			return nil, false, errorAt(fmt.Errorf(`missing closing "}}" of conditional section`), cp.lineNum, cp.colNum(), p.input)
+		}
+		if p.skipString("}}") {
+			p.add(nil)
+			break
+		}
+		if strings.HasPrefix(p.input[p.pos:], "{{") {
+			return nil, false, errorAt(fmt.Errorf("cannot nest conditional sections"), p.lineNum, p.colNum(), p.input)
+		}
+
+		exprStart := p.save()
+		if e, ok, err := p.parseExpr(); err != nil {
+			return nil, false, err
+		} else if ok {
+			if _, ok := e.(*outputExpr); ok {
+				return nil, false, errorAt(fmt.Errorf("cannot use output expression in conditional section"), exprStart.lineNum, exprStart.colNum(), p.input)
+			}
+			hasInput = true
+			p.add(e)
+		}
+	}
+	if !hasInput {
+		return nil, false, errorAt(fmt.Errorf("no input expressions in conditional section"), cp.lineNum, cp.colNum(), p.input)
+	}
+
+	ce := &conditionalExpr{exprs: p.exprs, raw: p.input[cp.pos:p.pos]}
+	p.exprs, p.prevExprEnd, p.currentExprStart = outerExprs, outerPrevExprEnd, outerCurrentExprStart
+	return ce, true, nil
+}
+
+// parsePositionalInput parses the position of a positional input expression
+// of the form "$1".
+func (p *Parser) parsePositionalInput() (*positionalInputExpr, bool, error) {
+	mark := p.pos
+	for p.pos < len(p.input) && '0' <= p.input[p.pos] && p.input[p.pos] <= '9' {
+		p.advanceByte()
+	}
+	if p.pos == mark {
+		return nil, false, nil
+	}
+	position, err := strconv.Atoi(p.input[mark:p.pos])
+	if err != nil || position < 1 {
+		return nil, false, fmt.Errorf("invalid positional input %q, positions start at 1", "$"+p.input[mark:p.pos])
+	}
+	return &positionalInputExpr{position: position}, true, nil
+}
+
+// inClauseRegexp matches the SQL preceding a slice input expression that is
+// the only value in an IN clause. The operand of the clause is a column, a
+// function call or a tuple of columns, for example "WHERE p.id NOT IN (",
+// `"id" IN (`, "lower(name) IN (" or "(a, b) IN (".
+var inClauseRegexp = regexp.MustCompile(`(?i)(?:^|[^\w."` + "`" + `])((` + inColumn + `|` + inFunction + `|\(\s*` + inColumn + `(?:\s*,\s*` + inColumn + `)*\s*\))\s+(not\s+)?in\s*\(\s*)$`)
+
+// inIdentifier matches a plain or quoted identifier in the operand of an IN
+// clause.
+const inIdentifier = `(?:[a-z_]\w*|"[^"]+"|` + "`[^`]+`" + `)`
+
+// inColumn matches a column, optionally prefixed by its table, in the operand
+// of an IN clause.
+const inColumn = `(?:` + inIdentifier + `\.)?` + inIdentifier
+
+// inFunction matches a function call without nested parentheses in the
+// operand of an IN clause, e.g. "lower(name)".
+const inFunction = `[a-z_]\w*\([^()]*\)`
+
+// inColumnRegexp matches a column in the operand of an IN clause, capturing
+// the column name without its table.
+var inColumnRegexp = regexp.MustCompile(`(?i)^(?:` + inIdentifier + `\.)?(` + inIdentifier + `)$`)
+
+// inClauseColumns returns the names of the columns in the operand of an IN
+// clause, which struct fields are matched against. Quotes and tables are
+// removed from the columns. An operand that is not a column or a tuple of
+// columns, such as a function call, is returned as it is.
+func inClauseColumns(operand string) []string {
+	parts := []string{operand}
+	if strings.HasPrefix(operand, "(") {
+		parts = strings.Split(strings.Trim(operand, "()"), ",")
+	}
+	var columns []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		m := inColumnRegexp.FindStringSubmatch(part)
+		if m == nil {
+			columns = append(columns, part)
+			continue
+		}
+		col := m[1]
+		if col[0] == '"' || col[0] == '`' {
+			col = col[1 : len(col)-1]
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// parseInClause checks if the slice input expression just parsed is the only
+// value in an IN clause, "col IN ($S[:])". If so, the whole clause is parsed
+// into an inExpr, since it must be rewritten when the slice is empty.
+func (p *Parser) parseInClause(slice *sliceInputExpr) (*inExpr, bool) {
+	cp := p.save()
+	m := inClauseRegexp.FindStringSubmatchIndex(p.input[p.prevExprEnd:p.currentExprStart])
+	if m == nil {
+		return nil, false
+	}
+	p.skipBlanks()
+	if !p.skipByte(')') {
+		cp.restore()
+		return nil, false
+	}
+	prefixStart := p.prevExprEnd + m[2]
+	slicePos := p.currentExprStart
+	p.currentExprStart = prefixStart
+	operand := p.input[p.prevExprEnd+m[4] : p.prevExprEnd+m[5]]
+	return &inExpr{
+		slice:   slice,
+		operand: operand,
+		columns: inClauseColumns(operand),
+		not:     m[6] != -1,
+		prefix:  p.input[prefixStart:slicePos],
+		suffix:  p.input[slicePos+len(slice.raw) : p.pos],
+		raw:     p.input[prefixStart:p.pos],
+	}, true
+}
+
 // parseInputExpr parses an input expression of the form "$Type.name".
 func (p *Parser) parseInputExpr() (expression, bool, error) {
 	cp := p.save()
@@ -723,17 +1093,30 @@ func (p *Parser) parseInputExpr() (expression, bool, error) {
 		return nil, false, nil
 	}
 
-	// Case 1: Slice range, "Type[:]".
+	// Case 1: Positional argument, "$1".
+	if in, ok, err := p.parsePositionalInput(); err != nil {
+		return nil, false, errorAt(err, cp.lineNum, cp.colNum(), p.input)
+	} else if ok {
+		in.raw = p.input[cp.pos:p.pos]
+		return in, true, nil
+	}
+
+	// Case 2: Slice range, "Type[:]".
 	if st, ok, err := p.parseSliceAccessor(); err != nil {
 		return nil, false, err
 	} else if ok {
-		return &sliceInputExpr{sliceTypeName: st, raw: p.input[cp.pos:p.pos]}, true, nil
+		slice := &sliceInputExpr{sliceTypeName: st, raw: p.input[cp.pos:p.pos]}
+		if in, ok := p.parseInClause(slice); ok {
+			return in, true, nil
+		}
+		return slice, true, nil
 	}
 
-	// Case 2: Struct or map, "Type.something".
+	// Case 3: Struct or map, "Type.something", or struct predicate,
+	// "Type.*".
 	if ma, ok, err := p.parseTypeAndMember(); ok {
 		if ma.memberName == "*" {
-			return nil, false, errorAt(fmt.Errorf("asterisk not allowed in input expression %q", "$"+ma.String()), cp.lineNum, cp.colNum(), p.input)
+			return &predicateInputExpr{typeName: ma.typeName, raw: p.input[cp.pos:p.pos]}, true, nil
 		}
 		return &memberInputExpr{ma: ma, raw: p.input[cp.pos:p.pos]}, true, nil
 	} else if err != nil {
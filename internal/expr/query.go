@@ -4,6 +4,7 @@
 package expr
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
 
@@ -18,6 +19,20 @@ type PrimedQuery struct {
 	params []any
 	// outputs specifies where to scan the query results.
 	outputs []typeinfo.Output
+	// outputColumns are the columns of the outputs, as written in the
+	// output expressions.
+	outputColumns []string
+	// nullResults specifies how NULL results are read into the outputs.
+	nullResults NullResults
+	// columnsOutput, if not nil, specifies where to scan the result columns
+	// that are not bound to outputs.
+	columnsOutput typeinfo.ColumnsOutput
+	// anonymousPositions maps the index of each anonymous output in outputs
+	// to its position among the anonymous output arguments.
+	anonymousPositions map[int]int
+	// shape records the properties of the input arguments that the SQL
+	// depends on. Input arguments with the same shape generate the same SQL.
+	shape []any
 }
 
 // Params returns the query parameters to pass with the SQL to a database.
@@ -28,7 +43,13 @@ func (pq *PrimedQuery) Params() []any {
 // HasOutputs returns true if the SQLair query contains at least one output
 // expression.
 func (pq *PrimedQuery) HasOutputs() bool {
-	return len(pq.outputs) > 0
+	return len(pq.outputs) > 0 || pq.columnsOutput != nil
+}
+
+// HasAnonymousOutputs returns true if the SQLair query contains at least one
+// anonymous output expression of the form "&_".
+func (pq *PrimedQuery) HasAnonymousOutputs() bool {
+	return len(pq.anonymousPositions) > 0
 }
 
 // SQL returns the SQL string to send to the database.
@@ -36,64 +57,301 @@ func (pq *PrimedQuery) SQL() string {
 	return pq.sql
 }
 
-// ScanArgs produces a list of pointers to be passed to rows.Scan. After a
-// successful call, the onSuccess function must be invoked. The outputArgs will
-// be populated with the query results. All the structs/maps/slices mentioned in
-// the query must be in outputArgs.
-func (pq *PrimedQuery) ScanArgs(columnNames []string, outputArgs []any) (scanArgs []any, onSuccess func(), err error) {
+// ScanPlan specifies where to scan each column of the query results for
+// output arguments of particular types. It is compiled for the first row and
+// reused while the output arguments have the same types, so the validation,
+// lookups and scan buffers are not repeated for every row.
+type ScanPlan struct {
+	// argTypes are the types of the output arguments the plan was compiled
+	// for.
+	argTypes []reflect.Type
+	// extra is true if the plan was compiled with an Extra map.
+	extra bool
+	// columns specifies where to scan each column of the results.
+	columns []columnPlan
+	// args holds the output arguments of the current row.
+	args []reflect.Value
+	// ptrs is the reusable list of pointers passed to rows.Scan.
+	ptrs []any
+	// discard is scanned into for columns not bound to an output.
+	discard any
+}
+
+// columnPlan specifies where to scan a column of the query results.
+type columnPlan struct {
+	// arg is the index of the output argument to scan into. It is extraArg
+	// for the Extra map and discardArg if the column is discarded.
+	arg   int
+	proxy *typeinfo.ScanProxy
+}
+
+const (
+	extraArg   = -1
+	discardArg = -2
+)
+
+// ScanPlan compiles a plan for scanning the query results into outputArgs.
+// All the structs/maps/slices mentioned in the query must be in outputArgs.
+// Pointers to other types are used, in order, for the anonymous outputs of
+// the query.
+//
+// Columns in the results that are not bound to an output expression are
+// stored in extra by column name if it is not nil. Otherwise, they are
+// discarded, unless strict is true in which case an error is returned.
+func (pq *PrimedQuery) ScanPlan(columns []*sql.ColumnType, outputArgs []any, extra map[string]any, strict bool) (*ScanPlan, error) {
+	// A result set may contain the columns of only some of the outputs, for
+	// queries with several result sets.
+	var columnInResult = make([]bool, len(pq.outputs))
+	for _, column := range columns {
+		if idx, ok := markerIndex(column.Name()); ok && idx < len(pq.outputs) {
+			columnInResult[idx] = true
+		}
+	}
+
+	var typedArgs []any
+	var typedIndexes, anonymousIndexes []int
+	// anonymousPositions maps the index of each anonymous output to the
+	// position of its argument among the anonymous output arguments.
+	anonymousPositions := pq.anonymousPositions
+	if pq.HasAnonymousOutputs() {
+		typedIndexes, anonymousIndexes = pq.splitAnonymousArgs(outputArgs)
+		if len(anonymousIndexes) != len(pq.anonymousPositions) {
+			// The anonymous output arguments can be for only the anonymous
+			// outputs in the result set.
+			inResult := pq.anonymousInResult(columnInResult)
+			if len(anonymousIndexes) != len(inResult) {
+				return nil, fmt.Errorf("need %d anonymous output arguments, got %d", len(inResult), len(anonymousIndexes))
+			}
+			anonymousPositions = inResult
+		}
+		for _, i := range typedIndexes {
+			typedArgs = append(typedArgs, outputArgs[i])
+		}
+	} else {
+		typedArgs = outputArgs
+		for i := range outputArgs {
+			typedIndexes = append(typedIndexes, i)
+		}
+	}
 
-	typeToValue, err := typeinfo.ValidateOutputs(outputArgs)
+	typeToValue, err := typeinfo.ValidateOutputs(typedArgs)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	typeToArg := map[reflect.Type]int{}
+	for _, i := range typedIndexes {
+		typeToArg[reflect.Indirect(reflect.ValueOf(outputArgs[i])).Type()] = i
+	}
+
+	plan := &ScanPlan{
+		extra: extra != nil,
+		args:  make([]reflect.Value, len(outputArgs)),
+		ptrs:  make([]any, len(columns)),
+	}
+	for _, arg := range outputArgs {
+		plan.argTypes = append(plan.argTypes, reflect.TypeOf(arg))
 	}
 
-	// Generate the pointers.
-	var ptrs []any
-	var scanProxies []typeinfo.ScanProxy
-	var columnInResult = make([]bool, len(columnNames))
 	argTypeUsed := map[reflect.Type]bool{}
-	for _, column := range columnNames {
-		idx, ok := markerIndex(column)
+	if pq.columnsOutput != nil {
+		argTypeUsed[pq.columnsOutput.ArgType()] = true
+	}
+	for _, column := range columns {
+		idx, ok := markerIndex(column.Name())
 		if !ok {
-			// Columns not mentioned in output expressions are scanned into x.
-			var x any
-			ptrs = append(ptrs, &x)
+			if pq.columnsOutput != nil {
+				// Columns not mentioned in output expressions are read into
+				// the columns output.
+				proxy, err := pq.columnsOutput.LocateColumnScanTarget(typeToValue, column.Name(), column.ScanType())
+				if err != nil {
+					return nil, err
+				}
+				plan.columns = append(plan.columns, columnPlan{arg: typeToArg[pq.columnsOutput.ArgType()], proxy: proxy})
+				continue
+			}
+			if extra != nil {
+				proxy := typeinfo.MapColumnScanTarget(reflect.TypeOf(extra), column.Name(), column.ScanType())
+				plan.columns = append(plan.columns, columnPlan{arg: extraArg, proxy: proxy})
+				continue
+			}
+			if strict {
+				return nil, fmt.Errorf("column %q not bound to an output expression", column.Name())
+			}
+			// Columns not mentioned in output expressions are discarded.
+			plan.columns = append(plan.columns, columnPlan{arg: discardArg})
 			continue
 		}
 		if idx >= len(pq.outputs) {
-			return nil, nil, fmt.Errorf("internal error: sqlair column not in outputs (%d>=%d)", idx, len(pq.outputs))
+			return nil, fmt.Errorf("internal error: sqlair column not in outputs (%d>=%d)", idx, len(pq.outputs))
 		}
-		columnInResult[idx] = true
 		output := pq.outputs[idx]
-		ptr, scanProxy, err := output.LocateScanTarget(typeToValue)
+		var cp columnPlan
+		if output == nil {
+			cp.arg = anonymousIndexes[anonymousPositions[idx]]
+			cp.proxy, err = typeinfo.LocateAnonymousScanTarget(reflect.ValueOf(outputArgs[cp.arg]))
+		} else {
+			cp.arg = typeToArg[output.ArgType()]
+			cp.proxy, err = output.LocateScanTarget(typeToValue)
+			argTypeUsed[output.ArgType()] = true
+		}
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		argTypeUsed[output.ArgType()] = true
-
-		ptrs = append(ptrs, ptr)
-		if scanProxy != nil {
-			scanProxies = append(scanProxies, *scanProxy)
+		if pq.nullResults == NullResultsError {
+			cp.proxy.RejectNull(pq.outputColumns[idx])
 		}
+		plan.columns = append(plan.columns, cp)
 	}
 
+	// Outputs without columns in the result set are an error if an output
+	// argument was provided for them.
 	for i := 0; i < len(pq.outputs); i++ {
-		if !columnInResult[i] {
-			return nil, nil, fmt.Errorf(`query uses "&%s" outside of result context`, pq.outputs[i].ArgType().Name())
+		if columnInResult[i] {
+			continue
+		}
+		if pq.outputs[i] == nil {
+			if _, ok := anonymousPositions[i]; ok {
+				return nil, fmt.Errorf(`query uses "&_" outside of result context`)
+			}
+		} else if _, ok := typeToValue[pq.outputs[i].ArgType()]; ok {
+			return nil, fmt.Errorf(`query uses "&%s" outside of result context`, pq.outputs[i].ArgType().Name())
 		}
 	}
 
 	for argType := range typeToValue {
 		if !argTypeUsed[argType] {
-			return nil, nil, fmt.Errorf("%q not referenced in query", argType.Name())
+			return nil, fmt.Errorf("%q not referenced in query", argType.Name())
+		}
+	}
+
+	return plan, nil
+}
+
+// Matches reports whether the plan can be used to scan into outputArgs and
+// extra. This is the case if they have the same types as the output
+// arguments the plan was compiled for and are not nil.
+func (plan *ScanPlan) Matches(outputArgs []any, extra map[string]any) bool {
+	if len(outputArgs) != len(plan.argTypes) || (extra != nil) != plan.extra {
+		return false
+	}
+	for i, arg := range outputArgs {
+		v := reflect.ValueOf(arg)
+		if !v.IsValid() || v.Type() != plan.argTypes[i] {
+			return false
+		}
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Map) && v.IsNil() {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanArgs produces a list of pointers to be passed to rows.Scan. The
+// outputArgs and extra must match the plan. After a successful call to
+// rows.Scan, OnSuccess must be invoked. The outputArgs will then be
+// populated with the query results.
+func (plan *ScanPlan) ScanArgs(outputArgs []any, extra map[string]any) []any {
+	for i, arg := range outputArgs {
+		plan.args[i] = reflect.Indirect(reflect.ValueOf(arg))
+	}
+	for i, cp := range plan.columns {
+		switch cp.arg {
+		case discardArg:
+			plan.ptrs[i] = &plan.discard
+		case extraArg:
+			plan.ptrs[i] = cp.proxy.ScanTarget(reflect.ValueOf(extra))
+		default:
+			plan.ptrs[i] = cp.proxy.ScanTarget(plan.args[cp.arg])
+		}
+	}
+	return plan.ptrs
+}
+
+// OnSuccess stores the results scanned by rows.Scan in the output arguments
+// passed to ScanArgs. A *typeinfo.NullError is returned if a column is NULL
+// and its output does not accept NULL.
+func (plan *ScanPlan) OnSuccess(extra map[string]any) error {
+	plan.discard = nil
+	for _, cp := range plan.columns {
+		var err error
+		switch cp.arg {
+		case discardArg:
+		case extraArg:
+			err = cp.proxy.OnSuccess(reflect.ValueOf(extra))
+		default:
+			err = cp.proxy.OnSuccess(plan.args[cp.arg])
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// anonymousInResult returns the positions of the anonymous outputs with
+// columns in a result set among the anonymous outputs of the result set, by
+// the index of the output.
+func (pq *PrimedQuery) anonymousInResult(columnInResult []bool) map[int]int {
+	inResult := map[int]int{}
+	for i, output := range pq.outputs {
+		if output == nil && columnInResult[i] {
+			inResult[i] = len(inResult)
+		}
+	}
+	return inResult
+}
+
+// OutputTypes returns the types of the struct and map output arguments of the
+// query.
+func (pq *PrimedQuery) OutputTypes() map[reflect.Type]bool {
+	outputTypes := map[reflect.Type]bool{}
+	for _, output := range pq.outputs {
+		if output != nil {
+			outputTypes[output.ArgType()] = true
+		}
+	}
+	if pq.columnsOutput != nil {
+		outputTypes[pq.columnsOutput.ArgType()] = true
+	}
+	return outputTypes
+}
 
-	onSuccess = func() {
-		for _, sp := range scanProxies {
-			sp.OnSuccess()
+// ResultSetOutputs returns the types of the struct and map output arguments
+// with columns in a result set of the query, and the number of anonymous
+// outputs with columns in it. The output of an asterisk map, "&M.*", is in
+// every result set with a column not bound to another output.
+func (pq *PrimedQuery) ResultSetOutputs(columns []*sql.ColumnType) (types map[reflect.Type]bool, anonymous int) {
+	types = map[reflect.Type]bool{}
+	for _, column := range columns {
+		idx, ok := markerIndex(column.Name())
+		switch {
+		case !ok:
+			if pq.columnsOutput != nil {
+				types[pq.columnsOutput.ArgType()] = true
+			}
+		case idx >= len(pq.outputs):
+		case pq.outputs[idx] == nil:
+			anonymous++
+		default:
+			types[pq.outputs[idx].ArgType()] = true
 		}
 	}
+	return types, anonymous
+}
 
-	return ptrs, onSuccess, nil
+// splitAnonymousArgs separates the output arguments for the anonymous outputs
+// from those for the structs and maps in the query, returning the indexes of
+// each in outputArgs. Pointers to values that are not structs or maps of
+// types in the query are anonymous output arguments.
+func (pq *PrimedQuery) splitAnonymousArgs(outputArgs []any) (typedIndexes []int, anonymousIndexes []int) {
+	outputTypes := pq.OutputTypes()
+	for i, arg := range outputArgs {
+		v := reflect.ValueOf(arg)
+		if v.Kind() == reflect.Pointer && !v.IsNil() && !outputTypes[v.Type().Elem()] {
+			anonymousIndexes = append(anonymousIndexes, i)
+			continue
+		}
+		typedIndexes = append(typedIndexes, i)
+	}
+	return typedIndexes, anonymousIndexes
 }
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ArgInfo is used to access type information about SQLair input and output
@@ -17,12 +18,22 @@ import (
 // locators.
 //
 // ArgInfo should only be accessed using it methods, not used directly as a
-// map.
-type ArgInfo map[string]arg
+// map. It maps each type name to the types with that name. Types that share
+// their name with another type must be referred to by their package
+// qualified name, e.g. "state.Model".
+type ArgInfo map[string][]arg
 
 // GenerateArgInfo takes sample instantiations of argument types and uses
-// reflection to generate an ArgInfo containing the types.
+// reflection to generate an ArgInfo containing the types. Struct fields are
+// mapped to columns with the default mapper.
 func GenerateArgInfo(typeSamples []any) (ArgInfo, error) {
+	return DefaultMapper().GenerateArgInfo(typeSamples)
+}
+
+// GenerateArgInfo takes sample instantiations of argument types and uses
+// reflection to generate an ArgInfo containing the types. Struct fields are
+// mapped to columns with the mapper.
+func (m *Mapper) GenerateArgInfo(typeSamples []any) (ArgInfo, error) {
 	argInfo := ArgInfo{}
 	for _, typeSample := range typeSamples {
 		if typeSample == nil {
@@ -34,17 +45,21 @@ func GenerateArgInfo(typeSamples []any) (ArgInfo, error) {
 			if t.Name() == "" {
 				return nil, fmt.Errorf("cannot use anonymous %s", t.Kind())
 			}
-			info, err := getArgInfo(t)
+			info, err := m.getArgInfo(t)
 			if err != nil {
 				return nil, err
 			}
-			if dupeArg, ok := argInfo[t.Name()]; ok {
+			for _, dupeArg := range argInfo[t.Name()] {
 				if dupeArg.typ() == t {
 					return nil, fmt.Errorf("found multiple instances of type %q", t.Name())
 				}
-				return nil, fmt.Errorf("two types found with name %q: %q and %q", t.Name(), dupeArg.typ().String(), t.String())
+				// Types with the same name can only be used together if
+				// their package qualified names differ.
+				if QualifiedName(dupeArg.typ()) == QualifiedName(t) {
+					return nil, fmt.Errorf("two types found with qualified name %q: %q and %q", QualifiedName(t), fullName(dupeArg.typ()), fullName(t))
+				}
 			}
-			argInfo[t.Name()] = info
+			argInfo[t.Name()] = append(argInfo[t.Name()], info)
 		case reflect.Pointer:
 			return nil, fmt.Errorf("need non-pointer type, got pointer to %s", t.Elem().Kind())
 		default:
@@ -54,6 +69,60 @@ func GenerateArgInfo(typeSamples []any) (ArgInfo, error) {
 	return argInfo, nil
 }
 
+// lookup returns the type information of the named type. The name can be
+// qualified by the name of the package of the type, e.g. "state.Model",
+// which is required if several types have the same name.
+func (argInfo ArgInfo) lookup(typeName string) (arg, error) {
+	name := typeName
+	qualified := strings.Contains(typeName, ".")
+	if qualified {
+		name = typeName[strings.LastIndex(typeName, ".")+1:]
+	}
+	args, ok := argInfo[name]
+	if !ok {
+		return nil, nameNotFoundError(argInfo, typeName)
+	}
+	if !qualified {
+		if len(args) > 1 {
+			var names []string
+			for _, arg := range args {
+				names = append(names, QualifiedName(arg.typ()))
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf(`type name %q is ambiguous, qualify it with its package (have "%s")`, typeName, strings.Join(names, `", "`))
+		}
+		return args[0], nil
+	}
+	for _, arg := range args {
+		if QualifiedName(arg.typ()) == typeName {
+			return arg, nil
+		}
+	}
+	return nil, nameNotFoundError(argInfo, typeName)
+}
+
+// QualifiedName returns the name of the type t qualified by the name of its
+// package, e.g. "state.Model". This is the package name used in Go source,
+// which can differ from the last element of the package path, as for
+// "gopkg.in/yaml.v3" or "github.com/mattn/go-sqlite3".
+func QualifiedName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.Name()
+	}
+	// The String method gives the package name rather than the package path.
+	s := t.String()
+	return s[:strings.IndexByte(s, '.')] + "." + t.Name()
+}
+
+// fullName returns the name of the type t qualified by its full package
+// path, e.g. "github.com/juju/juju/state.Model".
+func fullName(t reflect.Type) string {
+	if t.PkgPath() == "" {
+		return t.Name()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
 // InputMember returns an input locator for a member of a struct or map.
 func (argInfo ArgInfo) InputMember(typeName string, memberName string) (Input, error) {
 	vl, err := argInfo.getMember(typeName, memberName)
@@ -84,9 +153,9 @@ func (argInfo ArgInfo) OutputMember(typeName string, memberName string) (Output,
 // of the named type along with the names of the members. If the type is not a
 // struct an error is returned.
 func (argInfo ArgInfo) AllStructOutputs(typeName string) ([]Output, []string, error) {
-	arg, ok := argInfo[typeName]
-	if !ok {
-		return nil, nil, nameNotFoundError(argInfo, typeName)
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, nil, err
 	}
 	si, ok := arg.(*structInfo)
 	if !ok {
@@ -100,7 +169,7 @@ func (argInfo ArgInfo) AllStructOutputs(typeName string) ([]Output, []string, er
 		}
 	}
 	if len(si.tags) == 0 {
-		return nil, nil, fmt.Errorf(`no "db" tags found in struct %q`, si.structType.Name())
+		return nil, nil, fmt.Errorf(`no %q tags found in struct %q`, si.mapper.tagKey(), si.structType.Name())
 	}
 
 	var outputs []Output
@@ -110,18 +179,65 @@ func (argInfo ArgInfo) AllStructOutputs(typeName string) ([]Output, []string, er
 	return outputs, si.tags, nil
 }
 
+// AllStructInputs returns a list of input locators that locate every member
+// of the named type along with the names of the members. If the type is not a
+// struct an error is returned.
+func (argInfo ArgInfo) AllStructInputs(typeName string) ([]Input, []string, error) {
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	si, ok := arg.(*structInfo)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot use %s with asterisk in input expression", arg.typ().Kind())
+	}
+	if len(si.tags) == 0 {
+		return nil, nil, fmt.Errorf(`no %q tags found in struct %q`, si.mapper.tagKey(), si.structType.Name())
+	}
+
+	var inputs []Input
+	for _, tag := range si.tags {
+		inputs = append(inputs, si.tagToField[tag])
+	}
+	return inputs, si.tags, nil
+}
+
+// AllColumnsOutput returns an output locator that scans every column of the
+// query results, that is not read into another output, into the named map. If
+// the type is not a map an error is returned.
+func (argInfo ArgInfo) AllColumnsOutput(typeName string) (ColumnsOutput, error) {
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, err
+	}
+	mi, ok := arg.(*mapInfo)
+	if !ok {
+		return nil, fmt.Errorf("cannot read all columns into %s", arg.typ().Kind())
+	}
+	return &mapColumns{mapType: mi.mapType}, nil
+}
+
+// Kind returns the kind of the named type.
+func (argInfo ArgInfo) Kind(typeName string) (reflect.Kind, error) {
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return reflect.Invalid, err
+	}
+	return arg.typ().Kind(), nil
+}
+
 // getMember finds a type and a member of it and returns a locator for the
 // member. If the type does not have members it returns an error.
 func (argInfo ArgInfo) getMember(typeName string, memberName string) (ValueLocator, error) {
-	arg, ok := argInfo[typeName]
-	if !ok {
-		return nil, nameNotFoundError(argInfo, typeName)
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, err
 	}
 	switch arg := arg.(type) {
 	case *structInfo:
 		structField, ok := arg.tagToField[memberName]
 		if !ok {
-			return nil, fmt.Errorf(`type %q has no %q db tag`, arg.structType.Name(), memberName)
+			return nil, fmt.Errorf(`type %q has no %q %s tag`, arg.structType.Name(), memberName, arg.mapper.tagKey())
 		}
 		return structField, nil
 	case *mapInfo:
@@ -133,9 +249,9 @@ func (argInfo ArgInfo) getMember(typeName string, memberName string) (ValueLocat
 
 // InputSlice returns an input locator for a slice.
 func (argInfo ArgInfo) InputSlice(typeName string) (Input, error) {
-	arg, ok := argInfo[typeName]
-	if !ok {
-		return nil, nameNotFoundError(argInfo, typeName)
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, err
 	}
 	si, ok := arg.(*sliceInfo)
 	if !ok {
@@ -144,6 +260,67 @@ func (argInfo ArgInfo) InputSlice(typeName string) (Input, error) {
 	return &slice{sliceType: si.sliceType}, nil
 }
 
+// InputSliceColumns returns an input locator for a slice whose elements are
+// compared against the given columns. If the elements are structs, the
+// parameters are the fields tagged with each column, in order, from every
+// element. Otherwise there must be a single column and the elements
+// themselves are the parameters.
+func (argInfo ArgInfo) InputSliceColumns(typeName string, columns []string) (Input, error) {
+	input, err := argInfo.InputSlice(typeName)
+	if err != nil {
+		return nil, err
+	}
+	s := input.(*slice)
+	arg, err := argInfo.lookup(typeName)
+	if err != nil {
+		return nil, err
+	}
+	mapper := arg.(*sliceInfo).mapper
+
+	elemType := s.sliceType.Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct || isValueType(elemType) {
+		if len(columns) > 1 {
+			return nil, fmt.Errorf("cannot compare %d columns with slice of %s", len(columns), elemType.Kind())
+		}
+		return s, nil
+	}
+
+	elemInfo, err := mapper.getArgInfo(elemType)
+	if err != nil {
+		return nil, err
+	}
+	si := elemInfo.(*structInfo)
+	for _, column := range columns {
+		f, ok := si.tagToField[column]
+		if !ok {
+			return nil, fmt.Errorf(`type %q has no %q %s tag`, elemType.Name(), column, mapper.tagKey())
+		}
+		s.fields = append(s.fields, f)
+	}
+	return s, nil
+}
+
+// isValueType reports whether a struct of type t is passed to the database
+// as a single value rather than as its tagged fields. This is the case for
+// time.Time, types implementing driver.Valuer and types with a registered
+// converter, or whose pointers do.
+func isValueType(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	if t == timeType || t.Implements(valuerInterface) || pt.Implements(valuerInterface) {
+		return true
+	}
+	if _, ok := converterFor(t); ok {
+		return true
+	}
+	_, ok := converterFor(pt)
+	return ok
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
 // arg exposes useful information about SQLair input/output argument types.
 type arg interface {
 	typ() reflect.Type
@@ -157,6 +334,9 @@ type structInfo struct {
 	tags []string
 
 	tagToField map[string]*structField
+
+	// mapper maps the fields to columns.
+	mapper *Mapper
 }
 
 func (si *structInfo) typ() reflect.Type {
@@ -175,22 +355,32 @@ func (mi *mapInfo) typ() reflect.Type {
 // sliceInfo stores a slice type
 type sliceInfo struct {
 	sliceType reflect.Type
+	// mapper maps the fields of struct elements to columns.
+	mapper *Mapper
 }
 
 func (si *sliceInfo) typ() reflect.Type {
 	return si.sliceType
 }
 
+// argInfoKey identifies the type information of a type generated with a
+// mapper.
+type argInfoKey struct {
+	mapper *Mapper
+	t      reflect.Type
+}
+
 // argInfoCache caches type reflection information across queries.
 var argInfoCacheMutex sync.RWMutex
-var argInfoCache = make(map[reflect.Type]arg)
+var argInfoCache = make(map[argInfoKey]arg)
 
 // getArgInfo returns type information useful for SQLair from a sample
 // instantiation of an argument type.
-func getArgInfo(t reflect.Type) (arg, error) {
+func (m *Mapper) getArgInfo(t reflect.Type) (arg, error) {
 	// Check cache for type
+	key := argInfoKey{mapper: m, t: t}
 	argInfoCacheMutex.RLock()
-	typeInfo, found := argInfoCache[t]
+	typeInfo, found := argInfoCache[key]
 	argInfoCacheMutex.RUnlock()
 	if found {
 		return typeInfo, nil
@@ -206,29 +396,58 @@ func getArgInfo(t reflect.Type) (arg, error) {
 		info := structInfo{
 			tagToField: make(map[string]*structField),
 			structType: t,
+			mapper:     m,
 		}
 		tags := []string{}
 
 		for i := 0; i < t.NumField(); i++ {
 			f := t.Field(i)
-			// Fields without a "db" tag are outside of SQLAir's remit.
-			tag := f.Tag.Get("db")
-			if tag == "" {
+			// Fields without a tag are outside of SQLAir's remit, unless
+			// the mapper maps their names to columns.
+			tag := f.Tag.Get(m.tagKey())
+			if tag == "-" {
 				continue
 			}
+			if tag == "" {
+				if m.ColumnName == nil || !f.IsExported() || f.Anonymous {
+					continue
+				}
+				tag = m.ColumnName(f.Name)
+				if tag == "" {
+					continue
+				}
+			}
 			if !f.IsExported() {
 				return nil, fmt.Errorf("field %q of struct %s not exported", f.Name, t.Name())
 			}
 
-			tag, omitEmpty, err := parseTag(tag)
+			tag, opts, err := parseTag(tag, m.tagKey())
 			if err != nil {
 				return nil, fmt.Errorf("cannot parse tag for field %s.%s: %s", t.Name(), f.Name, err)
 			}
+			if opts.array || opts.split != "" {
+				if err := validateSliceOption(f.Type); err != nil {
+					return nil, fmt.Errorf("cannot use tag for field %s.%s: %s", t.Name(), f.Name, err)
+				}
+			}
+			if opts.def != "" {
+				if _, err := parseDefault(f.Type, opts.def); err != nil {
+					return nil, fmt.Errorf("cannot use tag for field %s.%s: %s", t.Name(), f.Name, err)
+				}
+			}
+			if dupe, ok := info.tagToField[tag]; ok {
+				return nil, fmt.Errorf("fields %s.%s and %s.%s have the same column %q", t.Name(), dupe.name, t.Name(), f.Name, tag)
+			}
 			tags = append(tags, tag)
 			info.tagToField[tag] = &structField{
 				name:       f.Name,
 				index:      i,
-				omitEmpty:  omitEmpty,
+				omitEmpty:  opts.omitEmpty,
+				json:       opts.json,
+				array:      opts.array,
+				split:      opts.split,
+				notNull:    opts.notNull,
+				def:        opts.def,
 				tag:        tag,
 				structType: t,
 			}
@@ -239,14 +458,14 @@ func getArgInfo(t reflect.Type) (arg, error) {
 
 		typeInfo = &info
 	case reflect.Slice:
-		return &sliceInfo{sliceType: t}, nil
+		return &sliceInfo{sliceType: t, mapper: m}, nil
 	default:
 		return nil, fmt.Errorf("internal error: cannot obtain type information for unsupported type: %s", t)
 	}
 
 	// Put type in cache.
 	argInfoCacheMutex.Lock()
-	argInfoCache[t] = typeInfo
+	argInfoCache[key] = typeInfo
 	argInfoCacheMutex.Unlock()
 
 	return typeInfo, nil
@@ -256,32 +475,83 @@ func getArgInfo(t reflect.Type) (arg, error) {
 // the parser.
 var validColNameRx = regexp.MustCompile(`^([a-zA-Z_])+([a-zA-Z_0-9])*$`)
 
-// parseTag parses the input tag string and returns its
-// name and whether it contains the "omitempty" option.
-func parseTag(tag string) (string, bool, error) {
-	options := strings.Split(tag, ",")
+// tagOptions holds the options of a "db" tag.
+type tagOptions struct {
+	// omitEmpty is true when the tag contains the "omitempty" option.
+	omitEmpty bool
+	// json is true when the tag contains the "json" option.
+	json bool
+	// array is true when the tag contains the "array" option.
+	array bool
+	// split is the separator given by the "split=" option.
+	split string
+	// notNull is true when the tag contains the "notnull" option.
+	notNull bool
+	// def is the default value given by the "default=" option.
+	def string
+}
+
+// parseTag parses the input tag string, the value of the tag with the given
+// key, and returns its name and options. The "split=" option must come last
+// since its separator may contain commas.
+func parseTag(tag string, key string) (string, tagOptions, error) {
+	var opts tagOptions
+	rest := tag
+	if i := strings.Index(tag, ",split="); i >= 0 {
+		opts.split = tag[i+len(",split="):]
+		if opts.split == "" {
+			return "", tagOptions{}, fmt.Errorf("empty separator in tag %q", tag)
+		}
+		rest = tag[:i]
+	}
+	options := strings.Split(rest, ",")
 
-	var omitEmpty bool
 	if len(options) > 1 {
 		for _, flag := range options[1:] {
-			if flag == "omitempty" {
-				omitEmpty = true
-			} else {
-				return "", omitEmpty, fmt.Errorf("unsupported flag %q in tag %q", flag, tag)
+			switch flag {
+			case "omitempty":
+				opts.omitEmpty = true
+			case "json":
+				opts.json = true
+			case "array":
+				opts.array = true
+			case "notnull":
+				opts.notNull = true
+			default:
+				if strings.HasPrefix(flag, "default=") {
+					opts.def = flag[len("default="):]
+					if opts.def == "" {
+						return "", tagOptions{}, fmt.Errorf("empty default in tag %q", tag)
+					}
+					continue
+				}
+				return "", tagOptions{}, fmt.Errorf("unsupported flag %q in tag %q", flag, tag)
 			}
 		}
 	}
+	encodings := 0
+	for _, set := range []bool{opts.json, opts.array, opts.split != ""} {
+		if set {
+			encodings++
+		}
+	}
+	if encodings > 1 {
+		return "", tagOptions{}, fmt.Errorf("json, array and split flags cannot be combined in tag %q", tag)
+	}
+	if opts.def != "" && (opts.notNull || encodings > 0) {
+		return "", tagOptions{}, fmt.Errorf("default flag cannot be combined with notnull, json, array or split flags in tag %q", tag)
+	}
 
 	name := options[0]
 	if len(name) == 0 {
-		return "", false, fmt.Errorf("empty db tag")
+		return "", tagOptions{}, fmt.Errorf("empty %s tag", key)
 	}
 
 	if !validColNameRx.MatchString(name) {
-		return "", false, fmt.Errorf("invalid column name in 'db' tag: %q", name)
+		return "", tagOptions{}, fmt.Errorf("invalid column name in '%s' tag: %q", key, name)
 	}
 
-	return name, omitEmpty, nil
+	return name, opts, nil
 }
 
 // nameNotFoundError generates the arguments present and returns a typeMissingError
@@ -4,9 +4,14 @@
 package typeinfo
 
 import (
+	"database/sql"
+	htmltemplate "html/template"
 	"reflect"
 	"testing"
+	texttemplate "text/template"
+	"time"
 
+	"github.com/mattn/go-sqlite3"
 	. "gopkg.in/check.v1"
 )
 
@@ -88,6 +93,87 @@ func (s *typeInfoSuite) TestArgInfoStruct(c *C) {
 	}
 }
 
+func (s *typeInfoSuite) TestArgInfoMapper(c *C) {
+	type myStruct struct {
+		UserID   int    `sql:"uid"`
+		FullName string `db:"name"`
+		Ignored  string `sql:"-"`
+		internal string
+	}
+
+	mapper := &Mapper{TagKey: "sql", ColumnName: SnakeCase}
+	argInfo, err := mapper.GenerateArgInfo([]any{myStruct{}})
+	c.Assert(err, IsNil)
+	_, names, err := argInfo.AllStructOutputs("myStruct")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"full_name", "uid"})
+
+	// The type information of each mapper is cached separately.
+	argInfo, err = GenerateArgInfo([]any{myStruct{}})
+	c.Assert(err, IsNil)
+	_, names, err = argInfo.AllStructOutputs("myStruct")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"name"})
+
+	type dupeStruct struct {
+		Name      string `db:"first_name"`
+		FirstName string
+	}
+	_, err = (&Mapper{ColumnName: SnakeCase}).GenerateArgInfo([]any{dupeStruct{}})
+	c.Assert(err, ErrorMatches, `fields dupeStruct.Name and dupeStruct.FirstName have the same column "first_name"`)
+
+	type untagged struct {
+		Name string `db:"name"`
+	}
+	argInfo, err = (&Mapper{TagKey: "sql"}).GenerateArgInfo([]any{untagged{}})
+	c.Assert(err, IsNil)
+	_, _, err = argInfo.AllStructInputs("untagged")
+	c.Assert(err, ErrorMatches, `no "sql" tags found in struct "untagged"`)
+
+	// Errors name the tag key of the mapper.
+	argInfo, err = mapper.GenerateArgInfo([]any{myStruct{}})
+	c.Assert(err, IsNil)
+	_, err = argInfo.InputMember("myStruct", "missing")
+	c.Assert(err, ErrorMatches, `type "myStruct" has no "missing" sql tag`)
+	type myStructs []myStruct
+	argInfo, err = mapper.GenerateArgInfo([]any{myStructs{}})
+	c.Assert(err, IsNil)
+	_, err = argInfo.InputSliceColumns("myStructs", []string{"uid", "missing"})
+	c.Assert(err, ErrorMatches, `type "myStruct" has no "missing" sql tag`)
+	type badTag struct {
+		ID int `sql:",notnull"`
+	}
+	_, err = mapper.GenerateArgInfo([]any{badTag{}})
+	c.Assert(err, ErrorMatches, `cannot parse tag for field badTag.ID: empty sql tag`)
+	type badColumn struct {
+		ID int `sql:"1d"`
+	}
+	_, err = mapper.GenerateArgInfo([]any{badColumn{}})
+	c.Assert(err, ErrorMatches, `cannot parse tag for field badColumn.ID: invalid column name in 'sql' tag: "1d"`)
+
+	// Resetting the default mapper reuses the same mapper, so that its type
+	// information is not cached again.
+	def := DefaultMapper()
+	SetDefaultMapper(mapper)
+	SetDefaultMapper(nil)
+	c.Assert(DefaultMapper() == def, Equals, true)
+}
+
+func (s *typeInfoSuite) TestSnakeCase(c *C) {
+	tests := []struct{ name, column string }{
+		{"ID", "id"},
+		{"UserID", "user_id"},
+		{"HTTPServer", "http_server"},
+		{"FirstName", "first_name"},
+		{"Address2Line", "address2_line"},
+		{"lower", "lower"},
+	}
+	for _, t := range tests {
+		c.Assert(SnakeCase(t.name), Equals, t.column)
+	}
+	c.Assert(LowerCase("UserID"), Equals, "userid")
+}
+
 func (s *typeInfoSuite) TestArgInfoMap(c *C) {
 	type myMap map[string]any
 
@@ -105,6 +191,45 @@ func (s *typeInfoSuite) TestArgInfoMap(c *C) {
 	c.Assert(output, DeepEquals, expectedMapKey)
 }
 
+func (s *typeInfoSuite) TestArgInfoQualifiedName(c *C) {
+	type Time struct {
+		ID int `db:"id"`
+	}
+
+	argInfo, err := GenerateArgInfo([]any{Time{}, time.Time{}})
+	c.Assert(err, IsNil)
+
+	input, err := argInfo.InputMember("typeinfo.Time", "id")
+	c.Assert(err, IsNil)
+	c.Assert(input.ArgType(), Equals, reflect.TypeOf(Time{}))
+
+	kind, err := argInfo.Kind("time.Time")
+	c.Assert(err, IsNil)
+	c.Assert(kind, Equals, reflect.Struct)
+
+	_, err = argInfo.InputMember("Time", "id")
+	c.Assert(err, ErrorMatches, `type name "Time" is ambiguous, qualify it with its package \(have "time.Time", "typeinfo.Time"\)`)
+
+	_, err = argInfo.Kind("other.Time")
+	c.Assert(err, ErrorMatches, `parameter with type "other.Time" missing .*`)
+
+	// The qualifier is the package name, which is an identifier even when
+	// the package path ends in a version or contains a hyphen.
+	argInfo, err = GenerateArgInfo([]any{C{}, sqlite3.SQLiteDriver{}})
+	c.Assert(err, IsNil)
+	kind, err = argInfo.Kind("check.C")
+	c.Assert(err, IsNil)
+	c.Assert(kind, Equals, reflect.Struct)
+	kind, err = argInfo.Kind("sqlite3.SQLiteDriver")
+	c.Assert(err, IsNil)
+	c.Assert(kind, Equals, reflect.Struct)
+	c.Assert(QualifiedName(reflect.TypeOf(sqlite3.SQLiteDriver{})), Equals, "sqlite3.SQLiteDriver")
+
+	// The packages text/template and html/template are both named template.
+	_, err = GenerateArgInfo([]any{texttemplate.Template{}, htmltemplate.Template{}})
+	c.Assert(err, ErrorMatches, `two types found with qualified name "template.Template": "text/template.Template" and "html/template.Template"`)
+}
+
 // This struct is used to test shadowed types in TestGenerateArgInfoInvalidTypeErrors
 type T struct{ foo int }
 
@@ -153,7 +278,7 @@ func (s *typeInfoSuite) TestGenerateArgInfoInvalidTypeErrors(c *C) {
 		err:  "need supported type, got array",
 	}, {
 		args: []any{t, T{}},
-		err:  `two types found with name "T": "typeinfo.T" and "typeinfo.T"`,
+		err:  `two types found with qualified name "typeinfo.T": "github.com/canonical/sqlair/internal/typeinfo.T" and "github.com/canonical/sqlair/internal/typeinfo.T"`,
 	}}
 
 	for _, t := range tests {
@@ -199,6 +324,48 @@ func (s *typeInfoSuite) TestGenerateArgInfoStructError(c *C) {
 	_, err = GenerateArgInfo([]any{S6{}})
 	c.Assert(err.Error(), Equals, `cannot parse tag for field S6.Foo: invalid column name in 'db' tag: "id$$"`)
 
+	type S7 struct {
+		Foo []int `db:"id,json,array"`
+	}
+	_, err = GenerateArgInfo([]any{S7{}})
+	c.Assert(err, ErrorMatches, `cannot parse tag for field S7.Foo: json, array and split flags cannot be combined in tag "id,json,array"`)
+
+	type S8 struct {
+		Foo []int `db:"id,split="`
+	}
+	_, err = GenerateArgInfo([]any{S8{}})
+	c.Assert(err, ErrorMatches, `cannot parse tag for field S8.Foo: empty separator in tag "id,split="`)
+
+	type S9 struct {
+		Foo int `db:"id,array"`
+	}
+	_, err = GenerateArgInfo([]any{S9{}})
+	c.Assert(err, ErrorMatches, `cannot use tag for field S9.Foo: need slice, got int`)
+
+	type S10 struct {
+		Foo []map[string]int `db:"id,split=;"`
+	}
+	_, err = GenerateArgInfo([]any{S10{}})
+	c.Assert(err, ErrorMatches, `cannot use tag for field S10.Foo: unsupported slice element type map\[string\]int`)
+
+	type S11 struct {
+		Foo int `db:"id,notnull,default=1"`
+	}
+	_, err = GenerateArgInfo([]any{S11{}})
+	c.Assert(err, ErrorMatches, `cannot parse tag for field S11.Foo: default flag cannot be combined with notnull, json, array or split flags in tag "id,notnull,default=1"`)
+
+	type S12 struct {
+		Foo int `db:"id,default=x"`
+	}
+	_, err = GenerateArgInfo([]any{S12{}})
+	c.Assert(err, ErrorMatches, `cannot use tag for field S12.Foo: invalid default: cannot parse "x" as int`)
+
+	type S13 struct {
+		Foo []int `db:"id,default=1"`
+	}
+	_, err = GenerateArgInfo([]any{S13{}})
+	c.Assert(err, ErrorMatches, `cannot use tag for field S13.Foo: invalid default: unsupported type \[\]int`)
+
 	type badMap map[int]any
 	_, err = GenerateArgInfo([]any{badMap{}})
 	c.Assert(err, ErrorMatches, "map type badMap must have key type string, found type int")
@@ -272,6 +439,40 @@ func (*typeInfoSuite) TestAllOutputMemberError(c *C) {
 	}
 }
 
+func (*typeInfoSuite) TestAllColumnsOutput(c *C) {
+	type mySlice []any
+	type myMap map[string]any
+	type myStruct struct {
+		Foo int `db:"foo"`
+	}
+	argInfo, err := GenerateArgInfo([]any{mySlice{}, myMap{}, myStruct{}})
+	c.Assert(err, IsNil)
+
+	output, err := argInfo.AllColumnsOutput("myMap")
+	c.Assert(err, IsNil)
+	c.Assert(output, DeepEquals, &mapColumns{mapType: reflect.TypeOf(myMap{})})
+
+	tests := []struct {
+		typeName string
+		err      string
+	}{{
+		typeName: "mySlice",
+		err:      "cannot read all columns into slice",
+	}, {
+		typeName: "myStruct",
+		err:      "cannot read all columns into struct",
+	}, {
+		typeName: "wrongMap",
+		err:      `parameter with type "wrongMap" missing (have "myMap", "mySlice", "myStruct")`,
+	}}
+
+	for i, test := range tests {
+		_, err = argInfo.AllColumnsOutput(test.typeName)
+		c.Assert(err, NotNil, Commentf("test %d failed", i+1))
+		c.Assert(err.Error(), Equals, test.err)
+	}
+}
+
 func (*typeInfoSuite) TestSliceInputError(c *C) {
 	type myMap map[string]any
 	type myStruct struct {
@@ -300,3 +501,95 @@ func (*typeInfoSuite) TestSliceInputError(c *C) {
 		c.Assert(err.Error(), Equals, test.err)
 	}
 }
+
+func (*typeInfoSuite) TestInputSliceColumns(c *C) {
+	type myStruct struct {
+		Foo int    `db:"foo"`
+		Bar string `db:"bar"`
+	}
+	type structSlice []*myStruct
+	type intSlice []int
+	argInfo, err := GenerateArgInfo([]any{structSlice{}, intSlice{}})
+	c.Assert(err, IsNil)
+
+	input, err := argInfo.InputSliceColumns("structSlice", []string{"bar", "foo"})
+	c.Assert(err, IsNil)
+	s := structSlice{{Foo: 1, Bar: "one"}, {Foo: 2, Bar: "two"}}
+	vals, err := input.LocateParams(TypeToValue{reflect.TypeOf(s): reflect.ValueOf(s)})
+	c.Assert(err, IsNil)
+	c.Assert(vals, HasLen, 4)
+	for i, expected := range []any{"one", 1, "two", 2} {
+		c.Assert(vals[i].Interface(), Equals, expected)
+	}
+
+	input, err = argInfo.InputSliceColumns("intSlice", []string{"foo"})
+	c.Assert(err, IsNil)
+	c.Assert(input, DeepEquals, &slice{sliceType: reflect.TypeOf(intSlice{})})
+
+	_, err = argInfo.InputSliceColumns("intSlice", []string{"foo", "bar"})
+	c.Assert(err, ErrorMatches, "cannot compare 2 columns with slice of int")
+
+	_, err = argInfo.InputSliceColumns("structSlice", []string{"baz"})
+	c.Assert(err, ErrorMatches, `type "myStruct" has no "baz" db tag`)
+}
+
+func (*typeInfoSuite) TestInputSliceColumnsValueStructs(c *C) {
+	type Times []time.Time
+	type NullStrings []*sql.NullString
+	type Point struct {
+		X int `db:"x"`
+	}
+	type Points []Point
+	RegisterConverter(reflect.TypeOf(Point{}), Converter{})
+	defer func() {
+		convertersMutex.Lock()
+		delete(converters, reflect.TypeOf(Point{}))
+		convertersMutex.Unlock()
+	}()
+	argInfo, err := GenerateArgInfo([]any{Times{}, NullStrings{}, Points{}})
+	c.Assert(err, IsNil)
+
+	// Structs passed to the database as a single value are not tuples.
+	for _, typeName := range []string{"Times", "NullStrings", "Points"} {
+		input, err := argInfo.InputSliceColumns(typeName, []string{"created"})
+		c.Assert(err, IsNil)
+		c.Assert(input.(*slice).fields, IsNil)
+	}
+	_, err = argInfo.InputSliceColumns("Times", []string{"created", "updated"})
+	c.Assert(err, ErrorMatches, "cannot compare 2 columns with slice of struct")
+}
+
+func (*typeInfoSuite) TestMembers(c *C) {
+	type myStruct struct {
+		Foo int    `db:"foo"`
+		Bar string `db:"bar"`
+	}
+	type structSlice []myStruct
+	type intSlice []int
+	type M map[string]any
+	argInfo, err := GenerateArgInfo([]any{myStruct{}, structSlice{}, intSlice{}, M{}})
+	c.Assert(err, IsNil)
+
+	input, err := argInfo.InputMember("myStruct", "bar")
+	c.Assert(err, IsNil)
+	c.Assert(Members(input), DeepEquals, []Member{{Name: "Bar", Column: "bar", Type: reflect.TypeOf("")}})
+
+	input, err = argInfo.InputSliceColumns("structSlice", []string{"bar", "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(Members(input), DeepEquals, []Member{
+		{Name: "Bar", Column: "bar", Type: reflect.TypeOf("")},
+		{Name: "Foo", Column: "foo", Type: reflect.TypeOf(0)},
+	})
+
+	input, err = argInfo.InputSlice("intSlice")
+	c.Assert(err, IsNil)
+	c.Assert(Members(input), DeepEquals, []Member{{Type: reflect.TypeOf(0)}})
+
+	output, err := argInfo.OutputMember("M", "baz")
+	c.Assert(err, IsNil)
+	c.Assert(Members(output), DeepEquals, []Member{{Name: "baz", Column: "baz", Type: reflect.TypeOf((*any)(nil)).Elem()}})
+
+	columns, err := argInfo.AllColumnsOutput("M")
+	c.Assert(err, IsNil)
+	c.Assert(Members(columns), DeepEquals, []Member{{Name: "*", Column: "*", Type: reflect.TypeOf((*any)(nil)).Elem()}})
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package typeinfo
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL dialect of the database. It determines how the fields
+// with the "array" tag option are passed as query parameters.
+type Dialect int
+
+const (
+	// DialectSQLite passes arrays as JSON arrays.
+	DialectSQLite Dialect = iota
+	// DialectPostgres passes arrays as Postgres array literals.
+	DialectPostgres
+)
+
+// DialectParam returns the query parameter val in the form required by the
+// dialect.
+func DialectParam(val any, dialect Dialect) any {
+	if a, ok := val.(arrayParam); ok {
+		a.dialect = dialect
+		return a
+	}
+	return val
+}
+
+// validateSliceOption checks that a field of type t can have the "array" or
+// "split" tag option. The field must be a slice, or a pointer to a slice, of
+// strings, integers, floats or booleans.
+func validateSliceOption(t reflect.Type) error {
+	t = indirectType(t)
+	if t.Kind() != reflect.Slice {
+		return fmt.Errorf("need slice, got %s", t)
+	}
+	switch t.Elem().Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	}
+	return fmt.Errorf("unsupported slice element type %s", t.Elem())
+}
+
+// arrayParam is the query parameter for a field with the "array" tag option.
+// It is formatted for the dialect when it is passed to the database.
+type arrayParam struct {
+	slice   reflect.Value
+	dialect Dialect
+}
+
+// Value implements driver.Valuer.
+func (a arrayParam) Value() (driver.Value, error) {
+	if a.dialect == DialectPostgres {
+		return formatPostgresArray(a.slice), nil
+	}
+	b, err := json.Marshal(a.slice.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+var _ driver.Valuer = arrayParam{}
+
+// arrayConverter returns a Converter for slices of type t stored in array
+// columns. Results are decoded from either JSON arrays or Postgres array
+// literals. Nil slices are stored as NULL.
+func arrayConverter(t reflect.Type) Converter {
+	return Converter{
+		ToDB: func(v reflect.Value) (any, error) {
+			if v.IsNil() {
+				return nil, nil
+			}
+			return arrayParam{slice: v}, nil
+		},
+		FromDB: func(src any) (reflect.Value, error) {
+			s, err := textValue(src)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			s = strings.TrimSpace(s)
+			if strings.HasPrefix(s, "{") {
+				return parsePostgresArray(s, t)
+			}
+			v := reflect.New(t)
+			if err := json.Unmarshal([]byte(s), v.Interface()); err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot decode JSON array into %s: %s", t, err)
+			}
+			return v.Elem(), nil
+		},
+	}
+}
+
+// splitConverter returns a Converter for slices of type t stored as their
+// elements joined with sep, as generated by GROUP_CONCAT. Nil slices are
+// stored as NULL.
+func splitConverter(t reflect.Type, sep string) Converter {
+	return Converter{
+		ToDB: func(v reflect.Value) (any, error) {
+			if v.IsNil() {
+				return nil, nil
+			}
+			elems := make([]string, v.Len())
+			for i := range elems {
+				elems[i] = formatElem(v.Index(i))
+			}
+			return strings.Join(elems, sep), nil
+		},
+		FromDB: func(src any) (reflect.Value, error) {
+			s, err := textValue(src)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			var elems []string
+			if s != "" {
+				elems = strings.Split(s, sep)
+			}
+			v := reflect.MakeSlice(t, len(elems), len(elems))
+			for i, elem := range elems {
+				if err := parseElem(elem, v.Index(i)); err != nil {
+					return reflect.Value{}, err
+				}
+			}
+			return v, nil
+		},
+	}
+}
+
+// textValue returns the text of a string or byte slice value from the
+// database.
+func textValue(src any) (string, error) {
+	switch src := src.(type) {
+	case string:
+		return src, nil
+	case []byte:
+		return string(src), nil
+	}
+	return "", fmt.Errorf("cannot decode %T, need text", src)
+}
+
+// formatElem formats an element of a slice as text.
+func formatElem(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// parseElem parses the text of a slice element into v.
+func parseElem(s string, v reflect.Value) error {
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(strings.TrimSpace(s))
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(strings.TrimSpace(s), 10, v.Type().Bits())
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(strings.TrimSpace(s), 10, v.Type().Bits())
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(strings.TrimSpace(s), v.Type().Bits())
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse %q as %s", s, v.Type())
+	}
+	return nil
+}
+
+// formatPostgresArray formats a slice as a Postgres array literal. Strings
+// are always quoted.
+func formatPostgresArray(slice reflect.Value) string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i := 0; i < slice.Len(); i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		elem := slice.Index(i)
+		if elem.Kind() != reflect.String {
+			sb.WriteString(formatElem(elem))
+			continue
+		}
+		sb.WriteString(`"`)
+		for _, r := range elem.String() {
+			if r == '"' || r == '\\' {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(r)
+		}
+		sb.WriteString(`"`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// parsePostgresArray parses a one-dimensional Postgres array literal into a
+// slice of type t. NULL elements are set to the zero value.
+func parsePostgresArray(s string, t reflect.Type) (reflect.Value, error) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return reflect.Value{}, fmt.Errorf("invalid array literal %q", s)
+	}
+	body := s[1 : len(s)-1]
+	v := reflect.MakeSlice(t, 0, 0)
+	if strings.TrimSpace(body) == "" {
+		return v, nil
+	}
+	for i := 0; ; {
+		var elem string
+		var quoted bool
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		if i < len(body) && body[i] == '"' {
+			quoted = true
+			var sb strings.Builder
+			i++
+			for ; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' {
+					i++
+					if i == len(body) {
+						break
+					}
+				}
+				sb.WriteByte(body[i])
+			}
+			if i == len(body) {
+				return reflect.Value{}, fmt.Errorf("missing closing quote in array literal %q", s)
+			}
+			i++
+			elem = sb.String()
+		} else {
+			start := i
+			for i < len(body) && body[i] != ',' {
+				if body[i] == '{' || body[i] == '"' {
+					return reflect.Value{}, fmt.Errorf("unsupported array literal %q", s)
+				}
+				i++
+			}
+			elem = strings.TrimSpace(body[start:i])
+		}
+		ev := reflect.New(t.Elem()).Elem()
+		if quoted || !strings.EqualFold(elem, "NULL") {
+			if err := parseElem(elem, ev); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		v = reflect.Append(v, ev)
+
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+		if i == len(body) {
+			return v, nil
+		}
+		if body[i] != ',' {
+			return reflect.Value{}, fmt.Errorf("invalid array literal %q", s)
+		}
+		i++
+	}
+}
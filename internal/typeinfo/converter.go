@@ -0,0 +1,113 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package typeinfo
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Converter converts values of a Go type to and from values the database
+// driver supports, for types that do not implement driver.Valuer and
+// sql.Scanner.
+type Converter struct {
+	// ToDB converts a value of the type to a query parameter.
+	ToDB func(v reflect.Value) (any, error)
+	// FromDB converts a non-NULL result column to a value of the type.
+	FromDB func(src any) (reflect.Value, error)
+}
+
+var converters = map[reflect.Type]Converter{}
+var convertersMutex sync.RWMutex
+
+// RegisterConverter registers the converter for the Go type t. It replaces
+// any converter previously registered for t.
+func RegisterConverter(t reflect.Type, c Converter) {
+	convertersMutex.Lock()
+	defer convertersMutex.Unlock()
+	converters[t] = c
+}
+
+// converterFor returns the converter registered for the type t, if any.
+func converterFor(t reflect.Type) (Converter, bool) {
+	convertersMutex.RLock()
+	defer convertersMutex.RUnlock()
+	c, ok := converters[t]
+	return c, ok
+}
+
+// convertParam converts a struct field value with a registered converter to
+// a query parameter. Pointers to the type are converted too, with nil
+// pointers passed as NULL. Values of other types are returned unchanged.
+func convertParam(v reflect.Value) (reflect.Value, error) {
+	c, ok := converterFor(indirectType(v.Type()))
+	if !ok {
+		return v, nil
+	}
+	return convertParamWith(v, c)
+}
+
+// convertParamWith converts v, a value of the converter's type or a pointer
+// to it, to a query parameter with the converter c.
+func convertParamWith(v reflect.Value, c Converter) (reflect.Value, error) {
+	t := indirectType(v.Type())
+	var param any
+	if v.Kind() != reflect.Pointer || !v.IsNil() {
+		var err error
+		param, err = c.ToDB(reflect.Indirect(v))
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot convert %s: %s", t, err)
+		}
+	}
+	// The parameter is returned as an interface value so that nil is valid.
+	return reflect.ValueOf(&param).Elem(), nil
+}
+
+// indirectType returns the type pointed to by t if t is a pointer, and t
+// otherwise.
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// converterScanner is a sql.Scanner that converts the value of a result
+// column with a registered converter and stores it in a Go value of the
+// converted type, or of a pointer to it.
+type converterScanner struct {
+	converter Converter
+	// value is the Go value the result is stored in.
+	value reflect.Value
+	// null is true if the last value scanned was NULL.
+	null bool
+}
+
+// Scan implements sql.Scanner.
+func (cs *converterScanner) Scan(src any) error {
+	cs.null = src == nil
+	if src == nil {
+		cs.value.Set(reflect.Zero(cs.value.Type()))
+		return nil
+	}
+	// The driver owns the memory of byte slices so they are copied.
+	if b, ok := src.([]byte); ok {
+		src = append([]byte{}, b...)
+	}
+	v, err := cs.converter.FromDB(src)
+	if err != nil {
+		return err
+	}
+	if cs.value.Kind() == reflect.Pointer {
+		p := reflect.New(cs.value.Type().Elem())
+		p.Elem().Set(v)
+		v = p
+	}
+	cs.value.Set(v)
+	return nil
+}
+
+var _ sql.Scanner = (*converterScanner)(nil)
@@ -0,0 +1,43 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package typeinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// jsonConverter returns a Converter that stores values of type t in the
+// database as JSON text. Nil maps and slices are stored as NULL.
+func jsonConverter(t reflect.Type) Converter {
+	return Converter{
+		ToDB: func(v reflect.Value) (any, error) {
+			if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil() {
+				return nil, nil
+			}
+			b, err := json.Marshal(v.Interface())
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		},
+		FromDB: func(src any) (reflect.Value, error) {
+			var b []byte
+			switch src := src.(type) {
+			case []byte:
+				b = src
+			case string:
+				b = []byte(src)
+			default:
+				return reflect.Value{}, fmt.Errorf("cannot decode JSON from %T", src)
+			}
+			v := reflect.New(t)
+			if err := json.Unmarshal(b, v.Interface()); err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot decode JSON into %s: %s", t, err)
+			}
+			return v.Elem(), nil
+		},
+	}
+}
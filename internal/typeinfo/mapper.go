@@ -0,0 +1,82 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package typeinfo
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Mapper specifies how the fields of struct types are mapped to columns.
+//
+// The type information generated with a Mapper is cached by its pointer and
+// never evicted, so each *Mapper should be created once and reused rather
+// than created for each query.
+type Mapper struct {
+	// TagKey is the key of the struct tags containing the column names and
+	// options of fields. It is "db" if empty.
+	TagKey string
+	// ColumnName, if not nil, maps the names of exported fields without a
+	// tag to column names. Fields mapped to "" are ignored. If ColumnName is
+	// nil, fields without a tag are ignored.
+	ColumnName func(fieldName string) string
+}
+
+// tagKey returns the key of the struct tags used by the mapper.
+func (m *Mapper) tagKey() string {
+	if m.TagKey == "" {
+		return "db"
+	}
+	return m.TagKey
+}
+
+// baseMapper is the mapper that uses "db" tags and ignores untagged fields.
+// It is reused so that its type information is cached only once.
+var baseMapper = &Mapper{}
+
+var defaultMapper = baseMapper
+var defaultMapperMutex sync.RWMutex
+
+// DefaultMapper returns the mapper used when none is specified.
+func DefaultMapper() *Mapper {
+	defaultMapperMutex.RLock()
+	defer defaultMapperMutex.RUnlock()
+	return defaultMapper
+}
+
+// SetDefaultMapper sets the mapper used when none is specified. If m is nil,
+// the "db" tags of fields are used and untagged fields are ignored.
+func SetDefaultMapper(m *Mapper) {
+	if m == nil {
+		m = baseMapper
+	}
+	defaultMapperMutex.Lock()
+	defer defaultMapperMutex.Unlock()
+	defaultMapper = m
+}
+
+// SnakeCase maps a Go field name to a snake case column name, e.g. "UserID"
+// to "user_id".
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteRune('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// LowerCase maps a Go field name to a lower case column name, e.g. "UserID"
+// to "userid".
+func LowerCase(name string) string {
+	return strings.ToLower(name)
+}
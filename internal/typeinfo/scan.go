@@ -3,37 +3,292 @@
 
 package typeinfo
 
-import "reflect"
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+)
 
-// ScanProxy is a shim for scanning query results
-// into struct fields or map keys.
+// ScanProxy is a shim for scanning query results into struct fields, map
+// keys or the values of anonymous output arguments. A ScanProxy holds
+// everything that does not depend on the output argument itself, such as the
+// field index and the scan buffer, so it is created once per query and reused
+// for every row.
 type ScanProxy struct {
-	// original is the reflected value of a map or struct field
-	// into which we want to scan SQL query results.
-	original reflect.Value
+	// field is the index of the struct field to scan into. It is -1 if the
+	// output argument is not a struct.
+	field int
 
-	// scan is the reflected value that we populated by rows.Scan
+	// scan, when valid, is the reusable pointer populated by rows.Scan
+	// before the result is stored in the output argument. It is not valid
+	// when rows.Scan can scan into the output argument directly.
 	scan reflect.Value
 
 	// key when valid indicates that this proxy is
-	// for a key in the map indicated by original.
+	// for a key in the map output argument.
 	key reflect.Value
+
+	// scanner, if not nil, is passed to rows.Scan in place of scan to
+	// convert the column value to its scan type.
+	scanner *columnScanner
+
+	// converter, if not nil, is passed to rows.Scan to convert the column
+	// value with a registered converter.
+	converter *converterScanner
+
+	// valueType is the type of the value scanned into.
+	valueType reflect.Type
+
+	// nullable is true if NULL results can be stored in the output as nil,
+	// or are handled by the output type itself.
+	nullable bool
+
+	// column is the name of the column used in errors.
+	column string
+
+	// notNull is true if OnSuccess returns a *NullError when the column is
+	// NULL.
+	notNull bool
+
+	// def, when valid, is the value stored when the column is NULL.
+	def reflect.Value
+}
+
+// NullError is returned when a NULL result is read into an output that does
+// not accept NULL.
+type NullError struct {
+	// Column is the name of the column containing NULL.
+	Column string
+}
+
+func (e *NullError) Error() string {
+	return fmt.Sprintf("unexpected NULL in column %q", e.Column)
+}
+
+// newValueScanProxy returns a ScanProxy for scanning into a value of type t,
+// in the struct field with the given index or, if the index is -1, in the
+// output argument itself.
+//
+// rows.Scan will return an error if it tries to scan NULL into a type that
+// cannot be set to nil, so for types that are not a pointer and do not
+// implement sql.Scanner, a pointer to them is passed to rows.Scan. If Scan
+// has set this pointer to nil the value is zeroed by OnSuccess.
+func newValueScanProxy(index int, t reflect.Type) *ScanProxy {
+	if c, ok := converterFor(indirectType(t)); ok {
+		return newConverterScanProxy(index, t, c)
+	}
+	pt := reflect.PointerTo(t)
+	sp := &ScanProxy{field: index, valueType: t, nullable: isNilable(t) || pt.Implements(scannerInterface)}
+	if t.Kind() != reflect.Pointer && !pt.Implements(scannerInterface) {
+		sp.scan = reflect.New(pt)
+	}
+	return sp
+}
+
+// newConverterScanProxy returns a ScanProxy for scanning into a value of type
+// t, the converter's type or a pointer to it, converting the column with c.
+// The index is that of newValueScanProxy.
+func newConverterScanProxy(index int, t reflect.Type, c Converter) *ScanProxy {
+	return &ScanProxy{field: index, valueType: t, nullable: isNilable(t), converter: &converterScanner{converter: c}}
+}
+
+// isNilable reports whether values of type t can be nil.
+func isNilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	}
+	return false
+}
+
+// RejectNull makes OnSuccess return a *NullError naming the column when NULL
+// is read into an output that cannot be set to nil and has no default value,
+// instead of setting it to its zero value. The column name is only used if
+// the proxy does not already have one, such as the tag of a struct field.
+func (sp *ScanProxy) RejectNull(column string) {
+	if sp.nullable || sp.key.IsValid() || sp.def.IsValid() {
+		return
+	}
+	if sp.column == "" {
+		sp.column = column
+	}
+	sp.notNull = true
+}
+
+// rejectNull makes OnSuccess return a *NullError when the column is NULL,
+// even if it could be stored in the output as nil.
+func (sp *ScanProxy) rejectNull() {
+	sp.notNull = true
+	sp.ensureScanBuffer()
+}
+
+// setDefault makes OnSuccess store def when the column is NULL. The def is a
+// value of the output type, or of the type it points to.
+func (sp *ScanProxy) setDefault(def reflect.Value) {
+	sp.def = def
+	sp.ensureScanBuffer()
+}
+
+// ensureScanBuffer makes rows.Scan scan into the scan buffer, so that
+// OnSuccess can tell when the column is NULL.
+func (sp *ScanProxy) ensureScanBuffer() {
+	if sp.converter != nil || sp.scan.IsValid() {
+		return
+	}
+	sp.scan = reflect.New(reflect.PointerTo(sp.valueType))
+}
+
+// newMapScanProxy returns a ScanProxy for scanning into the given key of a
+// map of type mapType. If the map values are of interface type and convert
+// is true, the column is scanned into a Go value matching scanType.
+func newMapScanProxy(mapType reflect.Type, key string, scanType reflect.Type, convert bool) *ScanProxy {
+	sp := &ScanProxy{field: -1, scan: reflect.New(mapType.Elem()), key: reflect.ValueOf(key)}
+	if convert && mapType.Elem().Kind() == reflect.Interface {
+		sp.scanner = &columnScanner{scanType: scanType, value: sp.scan.Elem()}
+	}
+	return sp
+}
+
+// ScanTarget returns the pointer to pass to rows.Scan to read a column into
+// arg. The arg is the struct or map output argument, or the value pointed to
+// by an anonymous output argument, and must be addressable if it is not a
+// map.
+func (sp *ScanProxy) ScanTarget(arg reflect.Value) any {
+	switch {
+	case sp.converter != nil:
+		if sp.field >= 0 {
+			sp.converter.value = arg.Field(sp.field)
+		} else {
+			sp.converter.value = arg
+		}
+		return sp.converter
+	case sp.scanner != nil:
+		return sp.scanner
+	case sp.scan.IsValid():
+		return sp.scan.Interface()
+	case sp.field >= 0:
+		return arg.Field(sp.field).Addr().Interface()
+	}
+	return arg.Addr().Interface()
 }
 
 // OnSuccess is run after using rows.Scan to read a single query column
-// return into the variable referenced by the scan member.
+// into the target returned by ScanTarget.
 // When the ScanProxy is for a map key, we set the map's value for the key.
 // When the proxy is for a struct field, we set that field.
-func (sp ScanProxy) OnSuccess() {
+// A *NullError is returned if the column is NULL and the proxy rejects NULL.
+func (sp *ScanProxy) OnSuccess(arg reflect.Value) error {
 	if sp.key.IsValid() {
-		sp.original.SetMapIndex(sp.key, sp.scan)
-	} else {
-		var val reflect.Value
-		if !sp.scan.IsNil() {
-			val = sp.scan.Elem()
-		} else {
-			val = reflect.Zero(sp.original.Type())
+		arg.SetMapIndex(sp.key, sp.scan.Elem())
+		return nil
+	}
+	original := arg
+	if sp.field >= 0 {
+		original = arg.Field(sp.field)
+	}
+	if sp.converter != nil {
+		if sp.converter.null {
+			return sp.onNull(original)
 		}
-		sp.original.Set(val)
+		return nil
+	}
+	if !sp.scan.IsValid() {
+		return nil
+	}
+	if p := sp.scan.Elem(); !p.IsNil() {
+		original.Set(p.Elem())
+		return nil
+	}
+	return sp.onNull(original)
+}
+
+// onNull stores the value for a NULL column in v, the default value if there
+// is one and the zero value otherwise.
+func (sp *ScanProxy) onNull(v reflect.Value) error {
+	switch {
+	case sp.notNull:
+		return &NullError{Column: sp.column}
+	case !sp.def.IsValid():
+		v.Set(reflect.Zero(v.Type()))
+	case v.Kind() == reflect.Pointer && sp.def.Type() != v.Type():
+		// Each row gets its own copy of the default.
+		p := reflect.New(sp.def.Type())
+		p.Elem().Set(sp.def)
+		v.Set(p)
+	default:
+		v.Set(sp.def)
+	}
+	return nil
+}
+
+// parseDefault parses the default value given in a tag for a field of type
+// t. The default is of type t, or of the type t points to.
+func parseDefault(t reflect.Type, s string) (reflect.Value, error) {
+	v := reflect.New(indirectType(t)).Elem()
+	if err := parseElem(s, v); err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid default: %s", err)
+	}
+	return v, nil
+}
+
+var valuerInterface = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+var rawBytesType = reflect.TypeOf(sql.RawBytes{})
+
+// columnScanner is a sql.Scanner that converts the value of a result column
+// into the Go type the driver reports for the column.
+type columnScanner struct {
+	// scanType is the Go type reported by the driver for the column.
+	scanType reflect.Type
+
+	// value is the interface value the result is stored in.
+	value reflect.Value
+}
+
+// Scan implements sql.Scanner.
+func (cs *columnScanner) Scan(src any) error {
+	if src == nil {
+		cs.value.Set(reflect.Zero(cs.value.Type()))
+		return nil
+	}
+	// The driver owns the memory of byte slices so they are copied.
+	if b, ok := src.([]byte); ok {
+		src = append([]byte{}, b...)
+	}
+
+	t := cs.scanType
+	switch {
+	case t == nil || t == rawBytesType || t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface:
+		// The column type gives no more information than the value itself.
+	case reflect.PointerTo(t).Implements(scannerInterface) && t.Implements(valuerInterface):
+		// Types such as sql.NullInt64 are used to convert the value, then
+		// the plain value is stored.
+		sv := reflect.New(t)
+		if err := sv.Interface().(sql.Scanner).Scan(src); err != nil {
+			return err
+		}
+		v, err := sv.Elem().Interface().(driver.Valuer).Value()
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			cs.value.Set(reflect.Zero(cs.value.Type()))
+			return nil
+		}
+		src = v
+	case canConvert(reflect.TypeOf(src), t):
+		cs.value.Set(reflect.ValueOf(src).Convert(t))
+		return nil
+	}
+	cs.value.Set(reflect.ValueOf(src))
+	return nil
+}
+
+// canConvert reports whether a driver value of type from can be converted to
+// type to without changing its meaning. Numbers are not converted to strings.
+func canConvert(from, to reflect.Type) bool {
+	if to.Kind() == reflect.String {
+		return from.Kind() == reflect.String || from == reflect.TypeOf([]byte{})
 	}
+	return from.ConvertibleTo(to)
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 )
 
 var scannerInterface = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
@@ -35,21 +36,91 @@ type Input interface {
 	LocateParams(typeToValue TypeToValue) ([]reflect.Value, error)
 }
 
+// InputIsZero reports whether the value located by the input is absent. This
+// is the case if it is missing from a map, nil, the zero value of its type or
+// an empty slice. An error is returned if typeToValue does not contain the
+// input argument.
+func InputIsZero(input Input, typeToValue TypeToValue) (bool, error) {
+	if mk, ok := input.(*mapKey); ok {
+		m, ok := typeToValue[mk.mapType]
+		if !ok {
+			return false, valueNotFoundError(typeToValue, mk.mapType)
+		}
+		if m.MapIndex(reflect.ValueOf(mk.name)).Kind() == reflect.Invalid {
+			return true, nil
+		}
+	}
+	vals, err := input.LocateParams(typeToValue)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := input.(*slice); ok {
+		return len(vals) == 0, nil
+	}
+	v := vals[0]
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v.IsZero(), nil
+}
+
 // Output is a locator for a target to scan results to in the SQLair output
 // arguments.
 type Output interface {
 	ValueLocator
 	// LocateScanTarget locates the output argument associated this Output in
-	// typeToValue and returns a pointer to the Go value within the output
-	// argument for rows.Scan, along with a ScanProxy for the cases where the
-	// output argument cannot be scanned into directly.
-	//
-	// rows.Scan will return an error if it tries to scan NULL into a type that
-	// cannot be set to nil, so for types that are not a pointer and do not
-	// implement sql.Scanner, a pointer to them is generated and passed to
-	// Rows.Scan. If Scan has set this pointer to nil the value is zeroed by
-	// ScanProxy.OnSuccess.
-	LocateScanTarget(typeToValue TypeToValue) (any, *ScanProxy, error)
+	// typeToValue and returns a ScanProxy for scanning query results into
+	// the Go value within output arguments of its type. An error is
+	// returned if typeToValue does not contain the output argument.
+	LocateScanTarget(typeToValue TypeToValue) (*ScanProxy, error)
+}
+
+// ColumnsOutput is a locator for a target to scan results into where the
+// columns are only known once the query has been run.
+type ColumnsOutput interface {
+	ValueLocator
+	// LocateColumnScanTarget locates the output argument associated with this
+	// ColumnsOutput in typeToValue and returns a ScanProxy for scanning the
+	// named column into output arguments of its type. The scanType is the Go
+	// type reported by the driver for the column and may be nil if it is not
+	// known.
+	LocateColumnScanTarget(typeToValue TypeToValue, column string, scanType reflect.Type) (*ScanProxy, error)
+}
+
+// Member describes a Go value located by a ValueLocator.
+type Member struct {
+	// Name is the name of the struct field or the map key. It is empty for
+	// the elements of a slice.
+	Name string
+	// Column is the column of the struct field, the map key, or "*" for all
+	// the columns stored in a map. It is empty for the elements of a slice.
+	Column string
+	// Type is the Go type of the value.
+	Type reflect.Type
+}
+
+// Members returns the members of the argument type located by vl. A slice of
+// structs in an IN clause has a member for each field passed from its
+// elements.
+func Members(vl ValueLocator) []Member {
+	switch vl := vl.(type) {
+	case *mapKey:
+		return []Member{{Name: vl.name, Column: vl.name, Type: vl.mapType.Elem()}}
+	case *mapColumns:
+		return []Member{{Name: "*", Column: "*", Type: vl.mapType.Elem()}}
+	case *structField:
+		return []Member{vl.member()}
+	case *slice:
+		if vl.fields == nil {
+			return []Member{{Type: vl.sliceType.Elem()}}
+		}
+		members := make([]Member, len(vl.fields))
+		for i, f := range vl.fields {
+			members[i] = f.member()
+		}
+		return members
+	}
+	return nil
 }
 
 // mapKey specifies at which key to find a value in a particular map.
@@ -88,20 +159,59 @@ func (mk *mapKey) Desc() string {
 // Identifier returns a string that uniquely identifies the map key in the
 // context of the query.
 func (mk *mapKey) Identifier() string {
-	return mk.mapType.Name() + "." + mk.name
+	return QualifiedName(mk.mapType) + "." + mk.name
 }
 
 // LocateScanTarget locates the map specified in mapKey from the provided
-// typeToValue map. It returns a pointer to pass to rows.Scan, and a ScanProxy
-// reference for setting the key value in the map once the pointer has been
-// scanned into.
-func (mk *mapKey) LocateScanTarget(typeToValue TypeToValue) (any, *ScanProxy, error) {
-	m, ok := typeToValue[mk.mapType]
-	if !ok {
-		return nil, nil, valueNotFoundError(typeToValue, mk.mapType)
+// typeToValue map. It returns a ScanProxy for setting the key value in maps of
+// its type.
+func (mk *mapKey) LocateScanTarget(typeToValue TypeToValue) (*ScanProxy, error) {
+	if _, ok := typeToValue[mk.mapType]; !ok {
+		return nil, valueNotFoundError(typeToValue, mk.mapType)
+	}
+	return newMapScanProxy(mk.mapType, mk.name, nil, false), nil
+}
+
+// mapColumns specifies a map to store all the columns of the query results,
+// keyed by column name.
+type mapColumns struct {
+	mapType reflect.Type
+}
+
+// ArgType returns the type of the map the columns are stored in.
+func (mc *mapColumns) ArgType() reflect.Type {
+	return mc.mapType
+}
+
+// Desc returns a natural language description of the mapColumns for use in
+// error messages.
+func (mc *mapColumns) Desc() string {
+	return fmt.Sprintf("all columns of map %q", mc.mapType.Name())
+}
+
+// Identifier returns a string that uniquely identifies the mapColumns in the
+// context of the query.
+func (mc *mapColumns) Identifier() string {
+	return QualifiedName(mc.mapType) + ".*"
+}
+
+// LocateColumnScanTarget locates the map specified in mapColumns from the
+// provided typeToValue map. It returns a ScanProxy for setting the column
+// value in maps of its type.
+func (mc *mapColumns) LocateColumnScanTarget(typeToValue TypeToValue, column string, scanType reflect.Type) (*ScanProxy, error) {
+	if _, ok := typeToValue[mc.mapType]; !ok {
+		return nil, valueNotFoundError(typeToValue, mc.mapType)
 	}
-	scanVal := reflect.New(mk.mapType.Elem()).Elem()
-	return scanVal.Addr().Interface(), &ScanProxy{original: m, scan: scanVal, key: reflect.ValueOf(mk.name)}, nil
+	return MapColumnScanTarget(mc.mapType, column, scanType), nil
+}
+
+// MapColumnScanTarget returns a ScanProxy for setting the value of the named
+// column in maps of type mapType.
+//
+// If the map values are of interface type, the column is scanned into a Go
+// value matching the scanType of the column.
+func MapColumnScanTarget(mapType reflect.Type, column string, scanType reflect.Type) *ScanProxy {
+	return newMapScanProxy(mapType, column, scanType, true)
 }
 
 // structField represents reflection information about a field of a particular
@@ -122,6 +232,26 @@ type structField struct {
 	// omitEmpty is true when "omitempty" is
 	// a property of the field's "db" tag.
 	omitEmpty bool
+
+	// json is true when "json" is a property of the field's "db" tag. The
+	// field is then stored in the database encoded as JSON.
+	json bool
+
+	// array is true when "array" is a property of the field's "db" tag. The
+	// slice field is then stored in an array column.
+	array bool
+
+	// split is the separator of the "split=" property of the field's "db"
+	// tag. The slice field is then stored as its elements joined by split.
+	split string
+
+	// notNull is true when "notnull" is a property of the field's "db" tag.
+	// Scanning NULL into the field is then an error.
+	notNull bool
+
+	// def is the value of the "default=" property of the field's "db" tag.
+	// When it is set, scanning NULL into the field sets it to the default.
+	def string
 }
 
 // ArgType returns the type of the struct this field is located in.
@@ -137,7 +267,40 @@ func (f *structField) LocateParams(typeToValue TypeToValue) ([]reflect.Value, er
 	if !ok {
 		return nil, valueNotFoundError(typeToValue, f.structType)
 	}
-	return []reflect.Value{s.Field(f.index)}, nil
+	v, err := f.param(s.Field(f.index))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", f.Desc(), err)
+	}
+	return []reflect.Value{v}, nil
+}
+
+// param returns the query parameter for v, the value of the field in a
+// struct.
+func (f *structField) param(v reflect.Value) (reflect.Value, error) {
+	if c, ok := f.converter(); ok {
+		return convertParamWith(v, c)
+	}
+	return convertParam(v)
+}
+
+// converter returns the converter for the field given by the options of its
+// tag, if any.
+func (f *structField) converter() (Converter, bool) {
+	t := indirectType(f.structType.Field(f.index).Type)
+	switch {
+	case f.json:
+		return jsonConverter(t), true
+	case f.array:
+		return arrayConverter(t), true
+	case f.split != "":
+		return splitConverter(t, f.split), true
+	}
+	return Converter{}, false
+}
+
+// member returns the description of the struct field.
+func (f *structField) member() Member {
+	return Member{Name: f.name, Column: f.tag, Type: f.structType.Field(f.index).Type}
 }
 
 // Desc returns a natural language description of the struct field for use in
@@ -149,34 +312,61 @@ func (f *structField) Desc() string {
 // Identifier returns a string that uniquely identifies the struct field in the
 // context of the query.
 func (f *structField) Identifier() string {
-	return f.structType.Name() + "." + f.tag
+	return QualifiedName(f.structType) + "." + f.tag
 }
 
 // LocateScanTarget locates the struct specified in structField from the
-// provided typeToValue map. It returns a pointer for the target of rows.Scan,
-// and a ScanProxy reference in the event that we need to coerce that pointer
-// into a struct field.
-func (f *structField) LocateScanTarget(typeToValue TypeToValue) (any, *ScanProxy, error) {
+// provided typeToValue map. It returns a ScanProxy for scanning into the field
+// in structs of its type.
+func (f *structField) LocateScanTarget(typeToValue TypeToValue) (*ScanProxy, error) {
 	s, ok := typeToValue[f.structType]
 	if !ok {
-		return nil, nil, valueNotFoundError(typeToValue, f.structType)
+		return nil, valueNotFoundError(typeToValue, f.structType)
+	}
+	if !s.Field(f.index).CanSet() {
+		return nil, fmt.Errorf("internal error: cannot set field %s of struct %s", f.name, f.structType.Name())
+	}
+	t := f.structType.Field(f.index).Type
+	var sp *ScanProxy
+	if c, ok := f.converter(); ok {
+		sp = newConverterScanProxy(f.index, t, c)
+	} else {
+		sp = newValueScanProxy(f.index, t)
+	}
+	sp.column = f.tag
+	if f.notNull {
+		sp.rejectNull()
 	}
-	val := s.Field(f.index)
-	if !val.CanSet() {
-		return nil, nil, fmt.Errorf("internal error: cannot set field %s of struct %s", f.name, f.structType.Name())
+	if f.def != "" {
+		def, err := parseDefault(t, f.def)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Desc(), err)
+		}
+		sp.setDefault(def)
 	}
+	return sp, nil
+}
 
-	pt := reflect.PointerTo(val.Type())
-	if val.Type().Kind() != reflect.Pointer && !pt.Implements(scannerInterface) {
-		scanVal := reflect.New(pt).Elem()
-		return scanVal.Addr().Interface(), &ScanProxy{original: val, scan: scanVal}, nil
+// LocateAnonymousScanTarget returns a ScanProxy for scanning into anonymous
+// output arguments of the same type as ptr, a non-nil pointer to a plain Go
+// value.
+func LocateAnonymousScanTarget(ptr reflect.Value) (*ScanProxy, error) {
+	if err := validateValue(ptr); err != nil {
+		return nil, err
 	}
-	return val.Addr().Interface(), nil, nil
+	if ptr.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("need pointer for anonymous output, got %s", ptr.Kind())
+	}
+	return newValueScanProxy(-1, ptr.Type().Elem()), nil
 }
 
 // slice represents a slice input.
 type slice struct {
 	sliceType reflect.Type
+	// fields are the struct fields passed as parameters from each element of
+	// a slice of structs, in order. It is nil if the elements are passed
+	// directly.
+	fields []*structField
 }
 
 // Desc returns a natural language description of the slice for use in error
@@ -188,7 +378,14 @@ func (s *slice) Desc() string {
 // Identifier returns a string that uniquely identifies the slice type in the
 // context of the query.
 func (s *slice) Identifier() string {
-	return s.sliceType.Name() + "[:]"
+	if s.fields == nil {
+		return QualifiedName(s.sliceType) + "[:]"
+	}
+	tags := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		tags[i] = f.tag
+	}
+	return QualifiedName(s.sliceType) + "[:](" + strings.Join(tags, ", ") + ")"
 }
 
 // ArgType is the type of the slice input to extract query parameters from.
@@ -207,7 +404,37 @@ func (s *slice) LocateParams(typeToValue TypeToValue) ([]reflect.Value, error) {
 
 	params := []reflect.Value{}
 	for i := 0; i < sv.Len(); i++ {
-		params = append(params, sv.Index(i))
+		elem := sv.Index(i)
+		if s.fields == nil {
+			// Registered converters also apply to the elements held in
+			// interface values, such as those of a sqlair.S.
+			v := elem
+			if v.Kind() == reflect.Interface && !v.IsNil() {
+				v = v.Elem()
+			}
+			if c, ok := converterFor(indirectType(v.Type())); ok {
+				cv, err := convertParamWith(v, c)
+				if err != nil {
+					return nil, fmt.Errorf("element at index %d of slice %q: %s", i, s.sliceType.Name(), err)
+				}
+				elem = cv
+			}
+			params = append(params, elem)
+			continue
+		}
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				return nil, fmt.Errorf("got nil pointer at index %d of slice %q", i, s.sliceType.Name())
+			}
+			elem = elem.Elem()
+		}
+		for _, f := range s.fields {
+			v, err := f.param(elem.Field(f.index))
+			if err != nil {
+				return nil, fmt.Errorf("%s at index %d of slice %q: %s", f.Desc(), i, s.sliceType.Name(), err)
+			}
+			params = append(params, v)
+		}
 	}
 	return params, nil
 }
@@ -4,6 +4,8 @@
 package typeinfo
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"reflect"
 
 	. "gopkg.in/check.v1"
@@ -24,21 +26,26 @@ func (s *typeInfoSuite) TestLocateScanTargetMap(c *C) {
 	}
 	// Values in maps cannot be set directly. A proxy is set by rows.Scan then
 	// we set it with the OnSuccess function in our map.
-	ptr, scanProxy, err := output.LocateScanTarget(typeToValue)
+	scanProxy, err := output.LocateScanTarget(typeToValue)
 	c.Assert(err, IsNil)
 
 	// Check scanProxy has the expected values.
-	c.Assert(scanProxy.original, Equals, valOfM)
 	c.Assert(scanProxy.key.Interface(), Equals, "foo")
-	c.Assert(scanProxy.scan, Not(IsNil))
+	ptr := scanProxy.ScanTarget(valOfM)
+	c.Assert(ptr, FitsTypeOf, (*any)(nil))
 
 	// Simulate rows.Scan
-	ptrVal := reflect.ValueOf(&ptr).Elem()
-	ptrVal.Set(reflect.ValueOf("bar"))
-	scanProxy.scan = ptrVal
+	*ptr.(*any) = "bar"
 
 	// Check that the value in the proxy was successfully set in the map.
-	scanProxy.OnSuccess()
+	scanProxy.OnSuccess(valOfM)
+	c.Assert(m["foo"], Equals, "bar")
+
+	// The proxy is reused for another map of the same type.
+	m2 := M{}
+	*scanProxy.ScanTarget(reflect.ValueOf(m2)).(*any) = "baz"
+	scanProxy.OnSuccess(reflect.ValueOf(m2))
+	c.Assert(m2["foo"], Equals, "baz")
 	c.Assert(m["foo"], Equals, "bar")
 }
 
@@ -62,32 +69,37 @@ func (s *typeInfoSuite) TestLocateScanTargetStruct(c *C) {
 	output, err := argInfo.OutputMember("T", "foo")
 	c.Assert(err, IsNil)
 
-	ptr, scanProxy, err := output.LocateScanTarget(typeToValue)
+	scanProxy, err := output.LocateScanTarget(typeToValue)
 	c.Assert(err, IsNil)
 
 	// Check scanProxy has the expected values.
-	c.Assert(scanProxy.original, Equals, valOfT.FieldByName("Foo"))
 	c.Assert(scanProxy.key, Equals, reflect.Value{})
-	c.Assert(scanProxy.scan.Interface(), Equals, (*string)(nil))
+	ptr := scanProxy.ScanTarget(valOfT)
+	c.Assert(ptr, FitsTypeOf, (**string)(nil))
 
 	// Simulate rows.Scan
-	ptrVal := reflect.ValueOf(&ptr).Elem()
-	ptrVal.Set(reflect.ValueOf("baz"))
-	scanProxy.scan = ptrVal
+	baz := "baz"
+	*ptr.(**string) = &baz
 
-	scanProxy.OnSuccess()
+	scanProxy.OnSuccess(valOfT)
 	// Check that the value in the proxy was successfully moved to the field of the struct.
 	c.Assert(t.Foo, Equals, "baz")
 
+	// Simulate rows.Scan of NULL.
+	*ptr.(**string) = nil
+	scanProxy.OnSuccess(valOfT)
+	c.Assert(t.Foo, Equals, "")
+
 	// Test field Bar which does not need proxy as it is indirected by a
 	// pointer.
 	output, err = argInfo.OutputMember("T", "bar")
 	c.Assert(err, IsNil)
 
-	ptr, scanProxy, err = output.LocateScanTarget(typeToValue)
+	scanProxy, err = output.LocateScanTarget(typeToValue)
 	c.Assert(err, IsNil)
-	c.Assert(scanProxy, IsNil)
-	c.Assert(ptr, FitsTypeOf, (**string)(nil))
+	c.Assert(scanProxy.scan.IsValid(), Equals, false)
+	ptr = scanProxy.ScanTarget(valOfT)
+	c.Assert(ptr, Equals, &t.Bar)
 }
 
 func (s *typeInfoSuite) TestLocateScanTargetError(c *C) {
@@ -103,14 +115,14 @@ func (s *typeInfoSuite) TestLocateScanTargetError(c *C) {
 	c.Assert(err, IsNil)
 
 	// Check missing type error.
-	_, _, err = output.LocateScanTarget(map[reflect.Type]reflect.Value{})
+	_, err = output.LocateScanTarget(map[reflect.Type]reflect.Value{})
 	c.Assert(err, ErrorMatches, `parameter with type "T" missing`)
 
 	output, err = argInfo.OutputMember("M", "baz")
 	c.Assert(err, IsNil)
 
 	// Check missing type error.
-	_, _, err = output.LocateScanTarget(map[reflect.Type]reflect.Value{})
+	_, err = output.LocateScanTarget(map[reflect.Type]reflect.Value{})
 	c.Assert(err, ErrorMatches, `parameter with type "M" missing`)
 
 	// Check missing type with same name error.
@@ -122,7 +134,7 @@ func (s *typeInfoSuite) TestLocateScanTargetError(c *C) {
 	{
 		type M map[string]any
 		typeToValue := map[reflect.Type]reflect.Value{reflect.TypeOf(M{}): reflect.ValueOf(M{})}
-		_, _, err = output.LocateScanTarget(typeToValue)
+		_, err = output.LocateScanTarget(typeToValue)
 		c.Assert(err, ErrorMatches, `parameter with type "typeinfo.M" missing, have type with same name: "typeinfo.M"`)
 	}
 }
@@ -173,6 +185,178 @@ func (s *typeInfoSuite) TestLocateParamsStruct(c *C) {
 	c.Assert(vals[0].Interface(), Equals, "bar")
 }
 
+func (s *typeInfoSuite) TestLocateParamsStructConverter(c *C) {
+	type Celsius struct {
+		Degrees float64
+	}
+	RegisterConverter(reflect.TypeOf(Celsius{}), Converter{
+		ToDB: func(v reflect.Value) (any, error) {
+			return v.Interface().(Celsius).Degrees, nil
+		},
+		FromDB: func(src any) (reflect.Value, error) {
+			return reflect.ValueOf(Celsius{Degrees: src.(float64)}), nil
+		},
+	})
+	type T struct {
+		Temp    Celsius  `db:"temp"`
+		MaxTemp *Celsius `db:"max_temp"`
+	}
+
+	argInfo, err := GenerateArgInfo([]any{T{}})
+	c.Assert(err, IsNil)
+
+	t := T{Temp: Celsius{Degrees: 21.5}}
+	typeToValue := map[reflect.Type]reflect.Value{
+		reflect.TypeOf(t): reflect.ValueOf(&t).Elem(),
+	}
+
+	input, err := argInfo.InputMember("T", "temp")
+	c.Assert(err, IsNil)
+	vals, err := input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), Equals, 21.5)
+
+	// Nil pointers are passed as NULL.
+	input, err = argInfo.InputMember("T", "max_temp")
+	c.Assert(err, IsNil)
+	vals, err = input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), IsNil)
+
+	// Results are converted when scanned.
+	output, err := argInfo.OutputMember("T", "max_temp")
+	c.Assert(err, IsNil)
+	scanProxy, err := output.LocateScanTarget(typeToValue)
+	c.Assert(err, IsNil)
+	scanner, ok := scanProxy.ScanTarget(typeToValue[reflect.TypeOf(t)]).(sql.Scanner)
+	c.Assert(ok, Equals, true)
+	c.Assert(scanner.Scan(30.0), IsNil)
+	c.Assert(*t.MaxTemp, Equals, Celsius{Degrees: 30})
+	c.Assert(scanner.Scan(nil), IsNil)
+	c.Assert(t.MaxTemp, IsNil)
+}
+
+func (s *typeInfoSuite) TestLocateParamsStructJSON(c *C) {
+	type Settings struct {
+		Theme string `json:"theme"`
+	}
+	type T struct {
+		Settings Settings          `db:"settings,json"`
+		Labels   map[string]string `db:"labels,json"`
+	}
+
+	argInfo, err := GenerateArgInfo([]any{T{}})
+	c.Assert(err, IsNil)
+
+	t := T{Settings: Settings{Theme: "dark"}}
+	typeToValue := map[reflect.Type]reflect.Value{
+		reflect.TypeOf(t): reflect.ValueOf(&t).Elem(),
+	}
+
+	input, err := argInfo.InputMember("T", "settings")
+	c.Assert(err, IsNil)
+	vals, err := input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), Equals, `{"theme":"dark"}`)
+
+	// Nil maps are passed as NULL.
+	input, err = argInfo.InputMember("T", "labels")
+	c.Assert(err, IsNil)
+	vals, err = input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), IsNil)
+
+	output, err := argInfo.OutputMember("T", "labels")
+	c.Assert(err, IsNil)
+	scanProxy, err := output.LocateScanTarget(typeToValue)
+	c.Assert(err, IsNil)
+	scanner, ok := scanProxy.ScanTarget(typeToValue[reflect.TypeOf(t)]).(sql.Scanner)
+	c.Assert(ok, Equals, true)
+	c.Assert(scanner.Scan([]byte(`{"a":"b"}`)), IsNil)
+	c.Assert(t.Labels, DeepEquals, map[string]string{"a": "b"})
+	c.Assert(scanner.Scan(nil), IsNil)
+	c.Assert(t.Labels, IsNil)
+	c.Assert(scanner.Scan("[1]"), ErrorMatches, `cannot decode JSON into map\[string\]string: json: cannot unmarshal array .*`)
+}
+
+func (s *typeInfoSuite) TestLocateParamsStructArray(c *C) {
+	type T struct {
+		Tags  []string `db:"tags,array"`
+		IDs   []int    `db:"ids,split=, "`
+		Flags []bool   `db:"flags,array"`
+	}
+
+	argInfo, err := GenerateArgInfo([]any{T{}})
+	c.Assert(err, IsNil)
+
+	t := T{Tags: []string{"a", `b "c"`, `d\e`}, IDs: []int{1, 2, 3}}
+	typeToValue := map[reflect.Type]reflect.Value{
+		reflect.TypeOf(t): reflect.ValueOf(&t).Elem(),
+	}
+
+	input, err := argInfo.InputMember("T", "tags")
+	c.Assert(err, IsNil)
+	vals, err := input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	valuer, ok := DialectParam(vals[0].Interface(), DialectSQLite).(driver.Valuer)
+	c.Assert(ok, Equals, true)
+	value, err := valuer.Value()
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, `["a","b \"c\"","d\\e"]`)
+	valuer, ok = DialectParam(vals[0].Interface(), DialectPostgres).(driver.Valuer)
+	c.Assert(ok, Equals, true)
+	value, err = valuer.Value()
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, `{"a","b \"c\"","d\\e"}`)
+
+	// Nil slices are passed as NULL.
+	input, err = argInfo.InputMember("T", "flags")
+	c.Assert(err, IsNil)
+	vals, err = input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), IsNil)
+
+	input, err = argInfo.InputMember("T", "ids")
+	c.Assert(err, IsNil)
+	vals, err = input.LocateParams(typeToValue)
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].Interface(), Equals, "1, 2, 3")
+
+	// Arrays are read from Postgres array literals and JSON arrays.
+	output, err := argInfo.OutputMember("T", "tags")
+	c.Assert(err, IsNil)
+	scanProxy, err := output.LocateScanTarget(typeToValue)
+	c.Assert(err, IsNil)
+	scanner := scanProxy.ScanTarget(typeToValue[reflect.TypeOf(t)]).(sql.Scanner)
+	c.Assert(scanner.Scan([]byte(`{a,"b \"c\"", NULL,"NULL"}`)), IsNil)
+	c.Assert(t.Tags, DeepEquals, []string{"a", `b "c"`, "", "NULL"})
+	c.Assert(scanner.Scan(`["x","y"]`), IsNil)
+	c.Assert(t.Tags, DeepEquals, []string{"x", "y"})
+	c.Assert(scanner.Scan("{}"), IsNil)
+	c.Assert(t.Tags, DeepEquals, []string{})
+	c.Assert(scanner.Scan(`{"a`), ErrorMatches, `invalid array literal .*`)
+	c.Assert(scanner.Scan(int64(1)), ErrorMatches, `cannot decode int64, need text`)
+
+	output, err = argInfo.OutputMember("T", "flags")
+	c.Assert(err, IsNil)
+	scanProxy, err = output.LocateScanTarget(typeToValue)
+	c.Assert(err, IsNil)
+	scanner = scanProxy.ScanTarget(typeToValue[reflect.TypeOf(t)]).(sql.Scanner)
+	c.Assert(scanner.Scan("{t,false}"), IsNil)
+	c.Assert(t.Flags, DeepEquals, []bool{true, false})
+
+	output, err = argInfo.OutputMember("T", "ids")
+	c.Assert(err, IsNil)
+	scanProxy, err = output.LocateScanTarget(typeToValue)
+	c.Assert(err, IsNil)
+	scanner = scanProxy.ScanTarget(typeToValue[reflect.TypeOf(t)]).(sql.Scanner)
+	c.Assert(scanner.Scan("4, 5"), IsNil)
+	c.Assert(t.IDs, DeepEquals, []int{4, 5})
+	c.Assert(scanner.Scan(nil), IsNil)
+	c.Assert(t.IDs, IsNil)
+	c.Assert(scanner.Scan("4, x"), ErrorMatches, `cannot parse "x" as int`)
+}
+
 func (s *typeInfoSuite) TestLocateParamsStructError(c *C) {
 	type T struct {
 		Foo string `db:"foo"`
@@ -273,3 +457,91 @@ func (*typeInfoSuite) TestLocateParamsSliceError(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, `parameter with type "S" missing (have "T")`)
 }
+
+func (*typeInfoSuite) TestInputIsZero(c *C) {
+	type M map[string]any
+	type S []int
+	type T struct {
+		Foo string  `db:"foo"`
+		Bar *string `db:"bar"`
+	}
+
+	argInfo, err := GenerateArgInfo([]any{M{}, S{}, T{}})
+	c.Assert(err, IsNil)
+
+	bar := ""
+	tests := []struct {
+		typeName   string
+		memberName string
+		arg        any
+		zero       bool
+	}{{
+		typeName:   "M",
+		memberName: "foo",
+		arg:        M{"foo": 1},
+		zero:       false,
+	}, {
+		typeName:   "M",
+		memberName: "foo",
+		arg:        M{"foo": 0},
+		zero:       true,
+	}, {
+		typeName:   "M",
+		memberName: "foo",
+		arg:        M{"foo": nil},
+		zero:       true,
+	}, {
+		typeName:   "M",
+		memberName: "foo",
+		arg:        M{},
+		zero:       true,
+	}, {
+		typeName: "S",
+		arg:      S{1},
+		zero:     false,
+	}, {
+		typeName: "S",
+		arg:      S{},
+		zero:     true,
+	}, {
+		typeName:   "T",
+		memberName: "foo",
+		arg:        T{Foo: "foo"},
+		zero:       false,
+	}, {
+		typeName:   "T",
+		memberName: "foo",
+		arg:        T{},
+		zero:       true,
+	}, {
+		typeName:   "T",
+		memberName: "bar",
+		arg:        T{Bar: &bar},
+		zero:       false,
+	}, {
+		typeName:   "T",
+		memberName: "bar",
+		arg:        T{},
+		zero:       true,
+	}}
+
+	for i, test := range tests {
+		var input Input
+		if test.memberName == "" {
+			input, err = argInfo.InputSlice(test.typeName)
+		} else {
+			input, err = argInfo.InputMember(test.typeName, test.memberName)
+		}
+		c.Assert(err, IsNil)
+
+		typeToValue := TypeToValue{reflect.TypeOf(test.arg): reflect.ValueOf(test.arg)}
+		zero, err := InputIsZero(input, typeToValue)
+		c.Assert(err, IsNil)
+		c.Assert(zero, Equals, test.zero, Commentf("test %d failed", i+1))
+	}
+
+	input, err := argInfo.InputMember("M", "foo")
+	c.Assert(err, IsNil)
+	_, err = InputIsZero(input, TypeToValue{})
+	c.Assert(err, ErrorMatches, `parameter with type "M" missing`)
+}
@@ -6,9 +6,15 @@ package sqlair_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	. "gopkg.in/check.v1"
@@ -535,6 +541,41 @@ func (s *PackageSuite) TestValidGet(c *C) {
 		inputs:   []any{},
 		outputs:  []any{sqlair.M{}},
 		expected: []any{sqlair.M{"avg": float64(2625), "name": "Fred"}},
+	}, {
+		summary:  "select all columns into map",
+		query:    "SELECT &M.* FROM person WHERE id = $Person.id",
+		types:    []any{sqlair.M{}, Person{}},
+		inputs:   []any{Person{ID: 30}},
+		outputs:  []any{sqlair.M{}},
+		expected: []any{sqlair.M{"name": "Fred", "id": int64(30), "address_id": int64(1000), "email": "fred@email.com"}},
+	}, {
+		summary:  "select all columns of table into map",
+		query:    "SELECT a.* AS &CustomMap.*, p.* AS &Person.* FROM person AS p, address AS a WHERE p.address_id = a.id AND p.id = 30",
+		types:    []any{CustomMap{}, Person{}},
+		inputs:   []any{},
+		outputs:  []any{CustomMap{}, &Person{}},
+		expected: []any{CustomMap{"id": int64(1000), "district": "Happy Land", "street": "Main Street"}, &Person{30, "Fred", 1000}},
+	}, {
+		summary:  "positional inputs",
+		query:    "SELECT &Person.* FROM person WHERE name = $1 AND address_id = $Address.id",
+		types:    []any{Person{}, Address{}},
+		inputs:   []any{"Fred", Address{ID: 1000}},
+		outputs:  []any{&Person{}},
+		expected: []any{&Person{30, "Fred", 1000}},
+	}, {
+		summary:  "struct predicate",
+		query:    "SELECT &Person.* FROM person WHERE $Person.*",
+		types:    []any{Person{}},
+		inputs:   []any{Person{Fullname: "Mark", PostalCode: 1500}},
+		outputs:  []any{&Person{}},
+		expected: []any{&Person{20, "Mark", 1500}},
+	}, {
+		summary:  "conditional sections",
+		query:    "SELECT &Person.* FROM person WHERE address_id = $M.postcode {{ AND name = $Person.name }} {{ AND id = $M.id }}",
+		types:    []any{Person{}, sqlair.M{}},
+		inputs:   []any{Person{Fullname: "Mark"}, sqlair.M{"postcode": 1500}},
+		outputs:  []any{&Person{}},
+		expected: []any{&Person{20, "Mark", 1500}},
 	}}
 
 	tables, sqldb, err := personAndAddressDB(c)
@@ -643,6 +684,13 @@ func (s *PackageSuite) TestValidGetAll(c *C) {
 		inputs:   []any{},
 		slices:   []any{&[]*Person{}},
 		expected: []any{&[]*Person{&Person{30, "Fred", 1000}, &Person{20, "Mark", 1500}, &Person{40, "Mary", 3500}, &Person{35, "James", 4500}}},
+	}, {
+		summary:  "order by identifier input",
+		query:    "SELECT &Person.* FROM person ORDER BY #M.column",
+		types:    []any{Person{}, sqlair.M{}, sqlair.Identifiers("id", "name")},
+		inputs:   []any{sqlair.M{"column": "name"}},
+		slices:   []any{&[]*Person{}},
+		expected: []any{&[]*Person{&Person{30, "Fred", 1000}, &Person{35, "James", 4500}, &Person{20, "Mark", 1500}, &Person{40, "Mary", 3500}}},
 	}, {
 		summary:  "select all columns into person with no pointers",
 		query:    "SELECT * AS &Person.* FROM person",
@@ -671,6 +719,13 @@ func (s *PackageSuite) TestValidGetAll(c *C) {
 		inputs:   []any{},
 		slices:   []any{&[]sqlair.M{}, &[]CustomMap{}},
 		expected: []any{&[]sqlair.M{{"name": "Mark"}}, &[]CustomMap{{"id": int64(20)}}},
+	}, {
+		summary:  "select all columns into maps",
+		query:    "SELECT * AS &M.* FROM address",
+		types:    []any{sqlair.M{}},
+		inputs:   []any{},
+		slices:   []any{&[]sqlair.M{}},
+		expected: []any{&[]sqlair.M{{"id": int64(1000), "district": "Happy Land", "street": "Main Street"}, {"id": int64(1500), "district": "Sad World", "street": "Church Road"}, {"id": int64(3500), "district": "Ambivalent Commons", "street": "Station Lane"}}},
 	}}
 
 	tables, sqldb, err := personAndAddressDB(c)
@@ -785,6 +840,240 @@ func (s *PackageSuite) TestGetAllErrors(c *C) {
 	}
 }
 
+func (s *PackageSuite) TestExtraColumns(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	stmt := sqlair.MustPrepare("SELECT email, &Person.* FROM person WHERE id = $Person.id", Person{})
+	fred := Person{ID: 30}
+
+	// Unbound columns are discarded by default.
+	var p = Person{}
+	err = db.Query(nil, stmt, fred).Get(&p)
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, Person{30, "Fred", 1000})
+
+	// Unbound columns are read into Extra.
+	var extra = sqlair.Extra{}
+	err = db.Query(nil, stmt, fred).Get(&p, extra)
+	c.Assert(err, IsNil)
+	c.Assert(extra, DeepEquals, sqlair.Extra{"email": "fred@email.com"})
+
+	// A strict query fails on unbound columns.
+	err = db.Query(nil, stmt, fred).Strict().Get(&p)
+	c.Assert(err, ErrorMatches, `cannot get result: column "email" not bound to an output expression`)
+
+	// A strict query with Extra succeeds.
+	extra = sqlair.Extra{}
+	err = db.Query(nil, stmt, fred).Strict().Get(&p, extra)
+	c.Assert(err, IsNil)
+	c.Assert(extra, DeepEquals, sqlair.Extra{"email": "fred@email.com"})
+
+	// Extra can be used with GetAll.
+	allStmt := sqlair.MustPrepare("SELECT email, &Person.* FROM person WHERE id < 35", Person{})
+	var people = []Person{}
+	var extras = []sqlair.Extra{}
+	err = db.Query(nil, allStmt).Strict().GetAll(&people, &extras)
+	c.Assert(err, IsNil)
+	c.Assert(people, DeepEquals, []Person{{30, "Fred", 1000}, {20, "Mark", 1500}})
+	c.Assert(extras, DeepEquals, []sqlair.Extra{{"email": "fred@email.com"}, {"email": "mark@email.com"}})
+
+	// Errors with Extra.
+	err = db.Query(nil, stmt, fred).Get(&p, sqlair.Extra{}, sqlair.Extra{})
+	c.Assert(err, ErrorMatches, `cannot get result: type "Extra" provided more than once`)
+	err = db.Query(nil, stmt, fred).Get(&p, (sqlair.Extra)(nil))
+	c.Assert(err, ErrorMatches, `cannot get result: got nil Extra`)
+}
+
+func (s *PackageSuite) TestAnonymousOutputs(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	// Get into plain pointers.
+	var count int64
+	countStmt := sqlair.MustPrepare("SELECT count(*) AS &_ FROM person")
+	err = db.Query(nil, countStmt).Get(&count)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(4))
+
+	// Anonymous outputs mixed with struct outputs.
+	var name string
+	var p = Person{}
+	mixedStmt := sqlair.MustPrepare("SELECT (name, id) AS (&_, &Person.id) FROM person WHERE id = $Person.id", Person{})
+	err = db.Query(nil, mixedStmt, Person{ID: 30}).Get(&p, &name)
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "Fred")
+	c.Assert(p, Equals, Person{ID: 30})
+
+	// NULL into pointers.
+	email := new(string)
+	insertNullPerson := sqlair.MustPrepare("INSERT INTO person VALUES ('Nully', 50, 50, NULL);")
+	c.Assert(db.Query(nil, insertNullPerson).Run(), IsNil)
+	nullStmt := sqlair.MustPrepare("SELECT email AS &_ FROM person WHERE name = 'Nully'")
+	err = db.Query(nil, nullStmt).Get(&email)
+	c.Assert(err, IsNil)
+	c.Assert(email, IsNil)
+
+	// GetAll into slices of plain types.
+	var names []string
+	var ids []*int
+	allStmt := sqlair.MustPrepare("SELECT (name, id) AS (&_, &_) FROM person WHERE id < 35")
+	err = db.Query(nil, allStmt).GetAll(&names, &ids)
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"Fred", "Mark"})
+	c.Assert(ids, HasLen, 2)
+	c.Assert(*ids[0], Equals, 30)
+	c.Assert(*ids[1], Equals, 20)
+
+	// Wrong number of anonymous outputs.
+	err = db.Query(nil, allStmt).Get(&name)
+	c.Assert(err, ErrorMatches, "cannot get result: need 2 anonymous output arguments, got 1")
+}
+
+func (s *PackageSuite) TestEmptySliceInClause(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	query := "SELECT &Person.* FROM person WHERE id IN ($S[:])"
+
+	// Without an option an empty slice is an error.
+	var people []Person
+	stmt := sqlair.MustPrepare(query, Person{}, sqlair.S{})
+	err = db.Query(nil, stmt, sqlair.S{}).GetAll(&people)
+	var emptySliceErr *sqlair.EmptySliceError
+	c.Assert(errors.As(err, &emptySliceErr), Equals, true)
+	c.Assert(emptySliceErr.SliceType, Equals, "S")
+
+	stmt = sqlair.MustPrepare(query, Person{}, sqlair.S{}, sqlair.EmptyInFalse)
+	err = db.Query(nil, stmt, sqlair.S{}).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, HasLen, 0)
+
+	stmt = sqlair.MustPrepare(query, Person{}, sqlair.S{}, sqlair.EmptyInTrue)
+	err = db.Query(nil, stmt, sqlair.S{}).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, HasLen, 4)
+}
+
+func (s *PackageSuite) TestLargeSlices(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	ids := make(sqlair.S, 40000)
+	for i := range ids {
+		ids[i] = i
+	}
+
+	var people []Person
+	stmt := sqlair.MustPrepare("SELECT &Person.* FROM person WHERE id IN ($S[:])", Person{}, sqlair.S{}, sqlair.SQLiteLargeSlices)
+	err = db.Query(nil, stmt, ids).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, HasLen, 4)
+
+	// The parameters after the IN clause count towards the limit.
+	stmt = sqlair.MustPrepare("SELECT &Person.* FROM person WHERE id IN ($S[:]) AND name = $Person.name", Person{}, sqlair.S{}, sqlair.SQLiteLargeSlices)
+	people = nil
+	err = db.Query(nil, stmt, ids[:32766], Person{Fullname: "Fred"}).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, DeepEquals, []Person{{30, "Fred", 1000}})
+
+	// Tuple IN clauses are passed as a JSON array of rows.
+	type People []Person
+	keys := make(People, 20000)
+	for i := range keys {
+		keys[i] = Person{ID: i, Fullname: "Fred"}
+	}
+	stmt = sqlair.MustPrepare("SELECT &Person.* FROM person WHERE (id, name) IN ($People[:])", Person{}, People{}, sqlair.SQLiteLargeSlices)
+	people = nil
+	err = db.Query(nil, stmt, keys).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, DeepEquals, []Person{{30, "Fred", 1000}})
+}
+
+func (s *PackageSuite) TestTupleInClause(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	type People []Person
+	var people []Person
+	stmt := sqlair.MustPrepare("SELECT &Person.* FROM person WHERE (name, id) IN ($People[:])", Person{}, People{})
+	err = db.Query(nil, stmt, People{{Fullname: "Fred", ID: 30}, {Fullname: "Mark", ID: 30}, {Fullname: "Mary", ID: 40}}).GetAll(&people)
+	c.Assert(err, IsNil)
+	c.Assert(people, DeepEquals, []Person{{30, "Fred", 1000}, {40, "Mary", 3500}})
+}
+
+func (s *PackageSuite) TestInClauseValueStructs(c *C) {
+	type Event struct {
+		Name    string    `db:"name"`
+		Created time.Time `db:"created"`
+	}
+	type Times []time.Time
+	type Names []sql.NullString
+	createTables := "CREATE TABLE event (name text, created timestamp);"
+	sqldb, err := createExampleDB(c, createTables, nil)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "event")
+
+	t0 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	insertStmt := sqlair.MustPrepare("INSERT INTO event (name, created) VALUES ($Event.name, $Event.created)", Event{})
+	for i, name := range []string{"start", "middle", "end"} {
+		err := db.Query(nil, insertStmt, Event{Name: name, Created: t0.Add(time.Duration(i) * time.Hour)}).Run()
+		c.Assert(err, IsNil)
+	}
+
+	var events []Event
+	stmt := sqlair.MustPrepare("SELECT &Event.* FROM event WHERE created IN ($Times[:])", Event{}, Times{})
+	err = db.Query(nil, stmt, Times{t0, t0.Add(2 * time.Hour)}).GetAll(&events)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Name, Equals, "start")
+	c.Assert(events[1].Name, Equals, "end")
+
+	events = nil
+	stmt = sqlair.MustPrepare("SELECT &Event.* FROM event WHERE name IN ($Names[:])", Event{}, Names{})
+	err = db.Query(nil, stmt, Names{{String: "middle", Valid: true}, {}}).GetAll(&events)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Assert(events[0].Name, Equals, "middle")
+
+	// Structs with a registered converter are converted and passed as
+	// single values.
+	type Place struct {
+		Name     string `db:"name"`
+		Location Point  `db:"location"`
+	}
+	type Points []Point
+	_, err = db.PlainDB().Exec("CREATE TABLE place (name text, location text)")
+	c.Assert(err, IsNil)
+	defer dropTables(c, db, "place")
+	insertStmt = sqlair.MustPrepare("INSERT INTO place (name, location) VALUES ($Place.name, $Place.location)", Place{})
+	places := []Place{{"origin", Point{0, 0}}, {"corner", Point{1, 1}}, {"edge", Point{0, 1}}}
+	for _, p := range places {
+		c.Assert(db.Query(nil, insertStmt, p).Run(), IsNil)
+	}
+	var got []Place
+	stmt = sqlair.MustPrepare("SELECT &Place.* FROM place WHERE location IN ($Points[:]) ORDER BY name", Place{}, Points{})
+	err = db.Query(nil, stmt, Points{{1, 1}, {0, 1}, {2, 2}}).GetAll(&got)
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, []Place{places[1], places[2]})
+}
+
 func (s *PackageSuite) TestRun(c *C) {
 	tables, sqldb, err := personAndAddressDB(c)
 	c.Assert(err, IsNil)
@@ -1159,6 +1448,904 @@ func (s *PackageSuite) TestIterMethodOrder(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *PackageSuite) TestIterGetChangingOutputs(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	stmt := sqlair.MustPrepare("SELECT &Person.*, email FROM person ORDER BY id", Person{})
+	iter := db.Query(nil, stmt).Iter()
+	defer iter.Close()
+
+	var p1, p2, p3 Person
+	c.Assert(iter.Next(), Equals, true)
+	c.Assert(iter.Get(&p1), IsNil)
+	c.Assert(iter.Next(), Equals, true)
+	c.Assert(iter.Get(&p2), IsNil)
+	c.Assert(p1, Equals, Person{ID: 20, Fullname: "Mark", PostalCode: 1500})
+	c.Assert(p2, Equals, Person{ID: 30, Fullname: "Fred", PostalCode: 1000})
+
+	// The output arguments can change between rows.
+	c.Assert(iter.Next(), Equals, true)
+	c.Assert(iter.Get((*Person)(nil)), ErrorMatches, "cannot get result: got nil pointer to Person")
+	extra := sqlair.Extra{}
+	c.Assert(iter.Get(&p3, extra), IsNil)
+	c.Assert(p3, Equals, Person{ID: 35, Fullname: "James", PostalCode: 4500})
+	c.Assert(extra, DeepEquals, sqlair.Extra{"email": "james@email.com"})
+
+	c.Assert(iter.Close(), IsNil)
+}
+
+func (s *PackageSuite) TestBoundStatement(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	insertStmt := sqlair.MustPrepare("INSERT INTO person (name, id, address_id) VALUES ($Person.name, $Person.id, $Person.address_id)", Person{})
+	insert := insertStmt.Bind(db)
+	for i := 0; i < 3; i++ {
+		err := insert.Query(nil, Person{ID: 100 + i, Fullname: fmt.Sprintf("Bound%d", i), PostalCode: 1000}).Run()
+		c.Assert(err, IsNil)
+	}
+	err = insert.Query(nil, Address{}).Run()
+	c.Assert(err, ErrorMatches, `invalid input parameter: parameter with type "Person" missing \(have "Address"\)`)
+
+	selectStmt := sqlair.MustPrepare("SELECT &Person.* FROM person WHERE id IN ($S[:]) ORDER BY id", Person{}, sqlair.S{})
+	var people []Person
+	q := db.Query(nil, selectStmt, sqlair.S{100, 101})
+	c.Assert(q.GetAll(&people), IsNil)
+	c.Assert(people, DeepEquals, []Person{{ID: 100, Fullname: "Bound0", PostalCode: 1000}, {ID: 101, Fullname: "Bound1", PostalCode: 1000}})
+
+	// Rebind with a slice of the same length.
+	people = nil
+	c.Assert(q.Rebind(sqlair.S{101, 102}).GetAll(&people), IsNil)
+	c.Assert(people, DeepEquals, []Person{{ID: 101, Fullname: "Bound1", PostalCode: 1000}, {ID: 102, Fullname: "Bound2", PostalCode: 1000}})
+
+	// Rebind with a slice of a different length.
+	people = nil
+	c.Assert(q.Rebind(sqlair.S{100, 101, 102}).GetAll(&people), IsNil)
+	c.Assert(people, HasLen, 3)
+
+	c.Assert(q.Rebind(Person{}).Run(), ErrorMatches, `invalid input parameter: .*`)
+}
+
+func (s *PackageSuite) TestExecMany(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	people := []any{
+		Person{ID: 100, Fullname: "A", PostalCode: 1000},
+		Person{ID: 101, Fullname: "B", PostalCode: 1000},
+		Person{ID: 102, Fullname: "C", PostalCode: 1500},
+	}
+	each := func(args ...any) func(yield func([]any) bool) {
+		return func(yield func([]any) bool) {
+			for _, arg := range args {
+				if !yield([]any{arg}) {
+					return
+				}
+			}
+		}
+	}
+
+	insertStmt := sqlair.MustPrepare("INSERT INTO person (name, id, address_id) VALUES ($Person.name, $Person.id, $Person.address_id)", Person{})
+	outcome, err := db.ExecMany(nil, insertStmt, each(people...))
+	c.Assert(err, IsNil)
+	c.Assert(outcome.Executions, Equals, 3)
+	c.Assert(outcome.RowsAffected, Equals, int64(3))
+	c.Assert(outcome.Errors, HasLen, 0)
+
+	// The executions continue after a failing set of input arguments, and
+	// the batch is rolled back.
+	outcome, err = db.ExecMany(nil, insertStmt, each(Person{ID: 103}, Address{}, Person{ID: 104}))
+	c.Assert(err, ErrorMatches, `cannot execute batch: 1 of 3 executions failed, first error at 1: invalid input parameter: parameter with type "Person" missing \(have "Address"\)`)
+	c.Assert(outcome.Executions, Equals, 3)
+	c.Assert(outcome.RowsAffected, Equals, int64(2))
+	c.Assert(outcome.Errors, HasLen, 1)
+
+	// ExecStopOnError stops the executions at the first failure.
+	outcome, err = db.ExecMany(nil, insertStmt, each(Person{ID: 103}, Address{}, Person{ID: 104}), sqlair.ExecStopOnError)
+	c.Assert(err, ErrorMatches, `cannot execute batch: 1 of 2 executions failed, first error at 1: .*`)
+	c.Assert(outcome.Executions, Equals, 2)
+	c.Assert(outcome.RowsAffected, Equals, int64(1))
+
+	var count int
+	countStmt := sqlair.MustPrepare("SELECT count(*) AS &_ FROM person WHERE id >= 100")
+	c.Assert(db.Query(nil, countStmt).Get(&count), IsNil)
+	c.Assert(count, Equals, 3)
+
+	// In a supplied transaction the caller commits.
+	updateStmt := sqlair.MustPrepare("UPDATE person SET address_id = 2000 WHERE address_id = $Person.address_id", Person{})
+	tx, err := db.Begin(nil, nil)
+	c.Assert(err, IsNil)
+	outcome, err = tx.ExecMany(nil, updateStmt, each(Person{PostalCode: 1000}, Person{PostalCode: 1500}))
+	c.Assert(err, IsNil)
+	c.Assert(outcome.RowsAffected, Equals, int64(5))
+
+	// The same options apply in a supplied transaction.
+	outcome, err = tx.ExecMany(nil, updateStmt, each(Person{PostalCode: 3500}, Address{}, Person{PostalCode: 4500}))
+	c.Assert(err, ErrorMatches, `cannot execute batch: 1 of 3 executions failed, first error at 1: .*`)
+	c.Assert(outcome.Executions, Equals, 3)
+	c.Assert(outcome.RowsAffected, Equals, int64(2))
+	outcome, err = tx.ExecMany(nil, updateStmt, each(Address{}, Person{PostalCode: 2000}), sqlair.ExecStopOnError)
+	c.Assert(err, ErrorMatches, `cannot execute batch: 1 of 1 executions failed, first error at 0: .*`)
+	c.Assert(outcome.Executions, Equals, 1)
+	c.Assert(tx.Commit(), IsNil)
+	_, err = tx.ExecMany(nil, updateStmt, each(Person{PostalCode: 2000}))
+	c.Assert(err, Equals, sqlair.ErrTXDone)
+
+	selectStmt := sqlair.MustPrepare("SELECT &Person.* FROM person WHERE id = $Person.id", Person{})
+	_, err = db.ExecMany(nil, selectStmt, each(Person{}))
+	c.Assert(err, ErrorMatches, "cannot execute batch: statement contains output expressions")
+}
+
+func (s *PackageSuite) TestExec(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	p := Person{ID: 100, Fullname: "Script", PostalCode: 5000}
+	a := Address{ID: 5000, District: "Script District", Street: "Script; Street"}
+	outcomes, err := db.Exec(nil, `
+-- Seed a person; and their address.
+INSERT INTO person (name, id, address_id) VALUES ($Person.name, $Person.id, $Person.address_id);
+INSERT INTO address (id, district, street) VALUES ($Address.id, $Address.district, $Address.street);
+UPDATE person SET name = 'Scripted;' WHERE address_id = $Address.id;
+`, &p, a)
+	c.Assert(err, IsNil)
+	c.Assert(outcomes, HasLen, 3)
+	for _, outcome := range outcomes {
+		n, err := outcome.Result().RowsAffected()
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, int64(1))
+	}
+
+	var gotPerson Person
+	var gotAddress Address
+	stmt := sqlair.MustPrepare("SELECT person.* AS &Person.*, address.* AS &Address.* FROM person JOIN address ON person.address_id = address.id WHERE person.id = 100", Person{}, Address{})
+	c.Assert(db.Query(nil, stmt).Get(&gotPerson, &gotAddress), IsNil)
+	c.Assert(gotPerson, Equals, Person{ID: 100, Fullname: "Scripted;", PostalCode: 5000})
+	c.Assert(gotAddress, Equals, a)
+
+	// Without a transaction the statements before a failure are not undone.
+	script := "DELETE FROM person WHERE id = $Person.id; INSERT INTO nonexistent VALUES (1)"
+	outcomes, err = db.Exec(nil, script, p)
+	c.Assert(err, ErrorMatches, "cannot run statement 2: no such table: nonexistent")
+	c.Assert(outcomes, HasLen, 1)
+
+	var count int
+	countStmt := sqlair.MustPrepare("SELECT count(*) AS &_ FROM person")
+	c.Assert(db.Query(nil, countStmt).Get(&count), IsNil)
+	c.Assert(count, Equals, 4)
+
+	// In a transaction they are rolled back.
+	outcomes, err = db.Exec(nil, "DELETE FROM person; INSERT INTO nonexistent VALUES (1)", sqlair.ExecInTransaction)
+	c.Assert(err, ErrorMatches, "cannot run statement 2: no such table: nonexistent")
+	c.Assert(outcomes, HasLen, 1)
+	c.Assert(db.Query(nil, countStmt).Get(&count), IsNil)
+	c.Assert(count, Equals, 4)
+
+	_, err = db.Exec(nil, "DELETE FROM person WHERE id = $Person.id; SELECT &Person.* FROM person", p)
+	c.Assert(err, ErrorMatches, "cannot run statement 2: output expressions cannot be used in scripts")
+	_, err = db.Exec(nil, "DELETE FROM person WHERE id = $Manager.id", p)
+	c.Assert(err, ErrorMatches, `statement 1: cannot prepare statement: input expression: parameter with type "Manager" missing \(have "Person"\): \$Manager.id`)
+}
+
+func (s *PackageSuite) TestExecTrigger(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, append(tables, "person_count")...)
+
+	// The statements in the body of a trigger are not split.
+	p := Person{ID: 100, Fullname: "Trigger", PostalCode: 1000}
+	outcomes, err := db.Exec(nil, `
+CREATE TABLE person_count (n integer);
+INSERT INTO person_count VALUES (0);
+CREATE TRIGGER count_person AFTER INSERT ON person BEGIN
+	UPDATE person_count SET n = n + 1;
+	UPDATE person_count SET n = CASE WHEN new.id >= 100 THEN n + 10 ELSE n END;
+END;
+INSERT INTO person (name, id, address_id) VALUES ($Person.name, $Person.id, $Person.address_id);
+`, p)
+	c.Assert(err, IsNil)
+	c.Assert(outcomes, HasLen, 4)
+
+	var n int
+	countStmt := sqlair.MustPrepare("SELECT n AS &_ FROM person_count")
+	c.Assert(db.Query(nil, countStmt).Get(&n), IsNil)
+	c.Assert(n, Equals, 11)
+
+	// ExecInTransaction has no effect in a transaction.
+	tx, err := db.Begin(nil, nil)
+	c.Assert(err, IsNil)
+	outcomes, err = tx.Exec(nil, "DELETE FROM person WHERE id = $Person.id", p, sqlair.ExecInTransaction)
+	c.Assert(err, IsNil)
+	c.Assert(outcomes, HasLen, 1)
+	c.Assert(tx.Commit(), IsNil)
+}
+
+func (s *PackageSuite) TestExecDollarQuoted(c *C) {
+	rc := &recordingConnector{}
+	db := sqlair.NewDB(sql.OpenDB(rc))
+
+	// Expressions in the body of a Postgres function are passed as they are.
+	p := Person{ID: 30}
+	outcomes, err := db.Exec(nil, `
+CREATE FUNCTION add_one(integer) RETURNS integer AS $$
+BEGIN
+	RETURN $1 + 1;
+END;
+$$ LANGUAGE plpgsql;
+DELETE FROM person WHERE id = $Person.id;
+`, p)
+	c.Assert(err, IsNil)
+	c.Assert(outcomes, HasLen, 2)
+	c.Assert(rc.queries, DeepEquals, []string{`CREATE FUNCTION add_one(integer) RETURNS integer AS $$
+BEGIN
+	RETURN $1 + 1;
+END;
+$$ LANGUAGE plpgsql`, "DELETE FROM person WHERE id = @sqlair_0"})
+	c.Assert(rc.args, DeepEquals, [][]driver.NamedValue{{}, {{Name: "sqlair_0", Ordinal: 1, Value: int64(30)}}})
+}
+
+// recordingConnector is a database connector for a driver that records the
+// statements it executes, and their arguments, without running them.
+type recordingConnector struct {
+	queries []string
+	args    [][]driver.NamedValue
+}
+
+func (rc *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{rc: rc}, nil
+}
+
+func (rc *recordingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type recordingConn struct {
+	rc *recordingConnector
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.rc.queries = append(c.rc.queries, query)
+	c.rc.args = append(c.rc.args, args)
+	return driver.RowsAffected(0), nil
+}
+
+// resultSetsConnector is a database connector for a driver that returns a
+// result set for each statement of a query, by running the statements one by
+// one on the wrapped database.
+type resultSetsConnector struct {
+	db *sql.DB
+}
+
+func (rc *resultSetsConnector) Connect(context.Context) (driver.Conn, error) {
+	return &resultSetsConn{db: rc.db}, nil
+}
+
+func (rc *resultSetsConnector) Driver() driver.Driver {
+	return nil
+}
+
+type resultSetsConn struct {
+	db *sql.DB
+}
+
+func (c *resultSetsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *resultSetsConn) Close() error {
+	return nil
+}
+
+func (c *resultSetsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *resultSetsConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	rs := &resultSetsRows{}
+	for _, q := range strings.Split(query, ";") {
+		if strings.TrimSpace(q) == "" {
+			continue
+		}
+		rows, err := c.db.QueryContext(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		cols, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		set := resultSet{cols: cols}
+		for rows.Next() {
+			row := make([]driver.Value, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range row {
+				ptrs[i] = &row[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return nil, err
+			}
+			set.rows = append(set.rows, row)
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		rs.sets = append(rs.sets, set)
+	}
+	return rs, nil
+}
+
+type resultSet struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+// resultSetsRows implements driver.RowsNextResultSet.
+type resultSetsRows struct {
+	sets []resultSet
+	set  int
+	row  int
+}
+
+func (r *resultSetsRows) Columns() []string {
+	return r.sets[r.set].cols
+}
+
+func (r *resultSetsRows) Close() error {
+	return nil
+}
+
+func (r *resultSetsRows) Next(dest []driver.Value) error {
+	if r.row >= len(r.sets[r.set].rows) {
+		return io.EOF
+	}
+	copy(dest, r.sets[r.set].rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *resultSetsRows) HasNextResultSet() bool {
+	return r.set < len(r.sets)-1
+}
+
+func (r *resultSetsRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.row = 0
+	return nil
+}
+
+func (s *PackageSuite) TestMultipleResultSets(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+	defer dropTables(c, sqlair.NewDB(sqldb), tables...)
+
+	db := sqlair.NewDB(sql.OpenDB(&resultSetsConnector{db: sqldb}))
+	stmt := sqlair.MustPrepare(`
+SELECT &Person.* FROM person WHERE id < 35 ORDER BY id;
+SELECT &Address.* FROM address ORDER BY id;
+SELECT count(*) AS &_ FROM person;`, Person{}, Address{})
+
+	expectedPeople := []Person{{ID: 20, Fullname: "Mark", PostalCode: 1500}, {ID: 30, Fullname: "Fred", PostalCode: 1000}}
+	expectedAddresses := []Address{
+		{ID: 1000, District: "Happy Land", Street: "Main Street"},
+		{ID: 1500, District: "Sad World", Street: "Church Road"},
+		{ID: 3500, District: "Ambivalent Commons", Street: "Station Lane"},
+	}
+
+	// Each result set is read with the outputs in it.
+	iter := db.Query(nil, stmt).Iter()
+	var people []Person
+	var addresses []Address
+	var count int
+	for iter.Next() {
+		var p Person
+		c.Assert(iter.Get(&p), IsNil)
+		people = append(people, p)
+	}
+	c.Assert(iter.NextResultSet(), Equals, true)
+	c.Assert(iter.Next(), Equals, true)
+	var p Person
+	var a Address
+	c.Assert(iter.Get(&p, &a), ErrorMatches, `cannot get result: query uses "&Person" outside of result context`)
+	c.Assert(iter.Get(&a), IsNil)
+	addresses = append(addresses, a)
+	for iter.Next() {
+		c.Assert(iter.Get(&a), IsNil)
+		addresses = append(addresses, a)
+	}
+	c.Assert(iter.NextResultSet(), Equals, true)
+	c.Assert(iter.Next(), Equals, true)
+	c.Assert(iter.Get(&count), IsNil)
+	c.Assert(iter.Next(), Equals, false)
+	c.Assert(iter.NextResultSet(), Equals, false)
+	c.Assert(iter.Close(), IsNil)
+	c.Assert(people, DeepEquals, expectedPeople)
+	c.Assert(addresses, DeepEquals, expectedAddresses)
+	c.Assert(count, Equals, 4)
+
+	// GetAll fills the slices from the result sets with their outputs.
+	people, addresses = nil, nil
+	var counts []int
+	err = db.Query(nil, stmt).GetAll(&people, &counts, &addresses)
+	c.Assert(err, IsNil)
+	c.Assert(people, DeepEquals, expectedPeople)
+	c.Assert(addresses, DeepEquals, expectedAddresses)
+	c.Assert(counts, DeepEquals, []int{4})
+}
+
+// Colour is an enum that does not implement driver.Valuer or sql.Scanner.
+type Colour int
+
+const (
+	Red Colour = iota + 1
+	Green
+)
+
+var colourNames = map[Colour]string{Red: "red", Green: "green"}
+
+// Point is a struct stored as a single "x,y" text column with a converter.
+type Point struct {
+	X, Y int
+}
+
+func init() {
+	sqlair.RegisterConverter(
+		func(a netip.Addr) (any, error) { return a.String(), nil },
+		func(src any) (netip.Addr, error) {
+			s, ok := src.(string)
+			if !ok {
+				return netip.Addr{}, fmt.Errorf("cannot convert %T to netip.Addr", src)
+			}
+			return netip.ParseAddr(s)
+		},
+	)
+	sqlair.RegisterConverter(
+		func(p Point) (any, error) { return fmt.Sprintf("%d,%d", p.X, p.Y), nil },
+		func(src any) (Point, error) {
+			var p Point
+			s, ok := src.(string)
+			if !ok {
+				return p, fmt.Errorf("cannot convert %T to Point", src)
+			}
+			_, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y)
+			return p, err
+		},
+	)
+	sqlair.RegisterConverter(
+		func(c Colour) (any, error) {
+			name, ok := colourNames[c]
+			if !ok {
+				return nil, fmt.Errorf("unknown colour %d", c)
+			}
+			return name, nil
+		},
+		func(src any) (Colour, error) {
+			for c, name := range colourNames {
+				if name == src {
+					return c, nil
+				}
+			}
+			return 0, fmt.Errorf("unknown colour %v", src)
+		},
+	)
+}
+
+func (s *PackageSuite) TestConverters(c *C) {
+	type Host struct {
+		Name    string      `db:"name"`
+		Addr    netip.Addr  `db:"addr"`
+		Gateway *netip.Addr `db:"gateway"`
+		Colour  Colour      `db:"colour"`
+	}
+	type Hosts []Host
+	createTables := "CREATE TABLE host (name text, addr text, gateway text, colour text);"
+	sqldb, err := createExampleDB(c, createTables, nil)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "host")
+
+	gateway := netip.MustParseAddr("10.0.0.1")
+	hosts := Hosts{
+		{Name: "a", Addr: netip.MustParseAddr("10.0.0.2"), Gateway: &gateway, Colour: Red},
+		{Name: "b", Addr: netip.MustParseAddr("::1"), Colour: Green},
+	}
+	insertStmt := sqlair.MustPrepare("INSERT INTO host (name, addr, gateway, colour) VALUES ($Host.name, $Host.addr, $Host.gateway, $Host.colour)", Host{})
+	for _, h := range hosts {
+		c.Assert(db.Query(nil, insertStmt, h).Run(), IsNil)
+	}
+
+	// The values are stored as converted.
+	var raw []sqlair.M
+	rawStmt := sqlair.MustPrepare("SELECT &M.* FROM host ORDER BY name", sqlair.M{})
+	c.Assert(db.Query(nil, rawStmt).GetAll(&raw), IsNil)
+	c.Assert(raw, DeepEquals, []sqlair.M{
+		{"name": "a", "addr": "10.0.0.2", "gateway": "10.0.0.1", "colour": "red"},
+		{"name": "b", "addr": "::1", "gateway": nil, "colour": "green"},
+	})
+
+	var got []Host
+	selectStmt := sqlair.MustPrepare("SELECT &Host.* FROM host WHERE colour IN ($Hosts[:]) ORDER BY name", Host{}, Hosts{})
+	c.Assert(db.Query(nil, selectStmt, hosts).GetAll(&got), IsNil)
+	c.Assert(got, DeepEquals, []Host(hosts))
+
+	// The elements of slices are converted, also when held in a sqlair.S.
+	type Addrs []netip.Addr
+	got = nil
+	inStmt := sqlair.MustPrepare("SELECT &Host.* FROM host WHERE addr IN ($Addrs[:]) ORDER BY name", Host{}, Addrs{})
+	c.Assert(db.Query(nil, inStmt, Addrs{netip.MustParseAddr("::1")}).GetAll(&got), IsNil)
+	c.Assert(got, DeepEquals, []Host{hosts[1]})
+	got = nil
+	sStmt := sqlair.MustPrepare("SELECT &Host.* FROM host WHERE colour IN ($S[:]) ORDER BY name", Host{}, sqlair.S{})
+	c.Assert(db.Query(nil, sStmt, sqlair.S{Red, "blue"}).GetAll(&got), IsNil)
+	c.Assert(got, DeepEquals, []Host{hosts[0]})
+
+	// Anonymous outputs are converted too.
+	var addr netip.Addr
+	addrStmt := sqlair.MustPrepare("SELECT addr AS &_ FROM host WHERE colour = $Host.colour", Host{})
+	c.Assert(db.Query(nil, addrStmt, Host{Colour: Green}).Get(&addr), IsNil)
+	c.Assert(addr, Equals, netip.MustParseAddr("::1"))
+
+	err = db.Query(nil, insertStmt, Host{Colour: 7}).Run()
+	c.Assert(err, ErrorMatches, `invalid input parameter: tag "colour" of struct "Host": cannot convert sqlair_test.Colour: unknown colour 7`)
+
+	var h Host
+	badStmt := sqlair.MustPrepare("SELECT name AS &Host.colour FROM host WHERE name = 'a'", Host{})
+	err = db.Query(nil, badStmt).Get(&h)
+	c.Assert(err, ErrorMatches, `cannot get result: sql: Scan error on column index 0, name "_sqlair_0": unknown colour a`)
+}
+
+func (s *PackageSuite) TestJSONColumns(c *C) {
+	type Settings struct {
+		Theme    string `json:"theme"`
+		FontSize int    `json:"font_size"`
+	}
+	type Account struct {
+		Name     string            `db:"name"`
+		Settings Settings          `db:"settings,json"`
+		Labels   map[string]string `db:"labels,json"`
+		Tags     []string          `db:"tags,json"`
+		Backup   *Settings         `db:"backup,json"`
+	}
+	createTables := "CREATE TABLE account (name text, settings text, labels text, tags text, backup text);"
+	sqldb, err := createExampleDB(c, createTables, nil)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "account")
+
+	accounts := []Account{{
+		Name:     "a",
+		Settings: Settings{Theme: "dark", FontSize: 12},
+		Labels:   map[string]string{"team": "db"},
+		Tags:     []string{"x", "y"},
+		Backup:   &Settings{Theme: "light"},
+	}, {
+		Name: "b",
+	}}
+	insertStmt := sqlair.MustPrepare("INSERT INTO account (name, settings, labels, tags, backup) VALUES ($Account.name, $Account.settings, $Account.labels, $Account.tags, $Account.backup)", Account{})
+	for _, a := range accounts {
+		c.Assert(db.Query(nil, insertStmt, a).Run(), IsNil)
+	}
+
+	// The values are stored as JSON, with nil maps, slices and pointers
+	// stored as NULL.
+	var raw []sqlair.M
+	rawStmt := sqlair.MustPrepare("SELECT &M.* FROM account ORDER BY name", sqlair.M{})
+	c.Assert(db.Query(nil, rawStmt).GetAll(&raw), IsNil)
+	c.Assert(raw, DeepEquals, []sqlair.M{
+		{"name": "a", "settings": `{"theme":"dark","font_size":12}`, "labels": `{"team":"db"}`, "tags": `["x","y"]`, "backup": `{"theme":"light","font_size":0}`},
+		{"name": "b", "settings": `{"theme":"","font_size":0}`, "labels": nil, "tags": nil, "backup": nil},
+	})
+
+	var got []Account
+	selectStmt := sqlair.MustPrepare("SELECT &Account.* FROM account ORDER BY name", Account{})
+	c.Assert(db.Query(nil, selectStmt).GetAll(&got), IsNil)
+	c.Assert(got, DeepEquals, accounts)
+
+	// NULL is read as the zero value, replacing the previous value.
+	a := Account{Settings: Settings{Theme: "old"}}
+	nullStmt := sqlair.MustPrepare("SELECT NULL AS &Account.settings", Account{})
+	c.Assert(db.Query(nil, nullStmt).Get(&a), IsNil)
+	c.Assert(a.Settings, Equals, Settings{})
+
+	badStmt := sqlair.MustPrepare("SELECT name AS &Account.tags FROM account WHERE name = 'a'", Account{})
+	err = db.Query(nil, badStmt).Get(&a)
+	c.Assert(err, ErrorMatches, `cannot get result: sql: Scan error on column index 0, name "_sqlair_0": cannot decode JSON into \[\]string: invalid character 'a' looking for beginning of value`)
+}
+
+func (s *PackageSuite) TestArrayColumns(c *C) {
+	type Post struct {
+		ID   int      `db:"id"`
+		Tags []string `db:"tags,array"`
+	}
+	type Author struct {
+		Name    string `db:"name"`
+		PostIDs []int  `db:"post_ids,split=,"`
+	}
+	createTables := `
+CREATE TABLE post (id integer, author text, tags text);
+`
+	sqldb, err := createExampleDB(c, createTables, nil)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "post")
+
+	posts := []Post{{ID: 1, Tags: []string{"go", "sql"}}, {ID: 2, Tags: []string{}}, {ID: 3}}
+	insertStmt := sqlair.MustPrepare("INSERT INTO post (id, author, tags) VALUES ($Post.id, 'fred', $Post.tags)", Post{})
+	for _, p := range posts {
+		c.Assert(db.Query(nil, insertStmt, p).Run(), IsNil)
+	}
+
+	// Arrays are stored as JSON arrays in SQLite.
+	var tags []string
+	rawStmt := sqlair.MustPrepare("SELECT tags AS &_ FROM post WHERE tags IS NOT NULL ORDER BY id")
+	c.Assert(db.Query(nil, rawStmt).GetAll(&tags), IsNil)
+	c.Assert(tags, DeepEquals, []string{`["go","sql"]`, `[]`})
+
+	var got []Post
+	selectStmt := sqlair.MustPrepare("SELECT &Post.* FROM post ORDER BY id", Post{})
+	c.Assert(db.Query(nil, selectStmt).GetAll(&got), IsNil)
+	c.Assert(got, DeepEquals, posts)
+
+	// The array can be used with SQLite's JSON functions.
+	var count int
+	countStmt := sqlair.MustPrepare("SELECT count(*) AS &_ FROM post, json_each(post.tags) WHERE json_each.value = 'sql'")
+	c.Assert(db.Query(nil, countStmt).Get(&count), IsNil)
+	c.Assert(count, Equals, 1)
+
+	var author Author
+	authorStmt := sqlair.MustPrepare("SELECT author AS &Author.name, GROUP_CONCAT(id) AS &Author.post_ids FROM post GROUP BY author", Author{})
+	c.Assert(db.Query(nil, authorStmt).Get(&author), IsNil)
+	c.Assert(author, DeepEquals, Author{Name: "fred", PostIDs: []int{1, 2, 3}})
+
+	// Arrays are passed as Postgres array literals with DialectPostgres.
+	pgStmt := sqlair.MustPrepare("INSERT INTO post (id, tags) VALUES ($Post.id, $Post.tags)", Post{}, sqlair.DialectPostgres)
+	c.Assert(db.Query(nil, pgStmt, Post{ID: 4, Tags: []string{"a b", `"c"`}}).Run(), IsNil)
+	var post Post
+	postStmt := sqlair.MustPrepare("SELECT &Post.* FROM post WHERE id = 4", Post{})
+	c.Assert(db.Query(nil, postStmt).Get(&post), IsNil)
+	c.Assert(post, DeepEquals, Post{ID: 4, Tags: []string{"a b", `"c"`}})
+	var literal string
+	literalStmt := sqlair.MustPrepare("SELECT tags AS &_ FROM post WHERE id = 4")
+	c.Assert(db.Query(nil, literalStmt).Get(&literal), IsNil)
+	c.Assert(literal, Equals, `{"a b","\"c\""}`)
+}
+
+func (s *PackageSuite) TestNullResults(c *C) {
+	type Item struct {
+		Name   string         `db:"name,notnull"`
+		Count  int            `db:"count,default=1"`
+		Price  *float64       `db:"price,default=9.5"`
+		Note   string         `db:"note"`
+		Origin sql.NullString `db:"origin"`
+	}
+	createTables := "CREATE TABLE item (name text, count integer, price real, note text, origin text);"
+	inserts := []string{
+		"INSERT INTO item VALUES ('a', NULL, NULL, NULL, NULL);",
+		"INSERT INTO item VALUES (NULL, 2, 3.5, 'n', 'o');",
+	}
+	sqldb, err := createExampleDB(c, createTables, inserts)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "item")
+
+	// Defaults are used for NULL columns.
+	var item Item
+	stmt := sqlair.MustPrepare("SELECT &Item.* FROM item WHERE name = 'a'", Item{})
+	c.Assert(db.Query(nil, stmt).Get(&item), IsNil)
+	price := 9.5
+	c.Assert(item, DeepEquals, Item{Name: "a", Count: 1, Price: &price})
+
+	// Fields with the notnull option reject NULL.
+	var items []Item
+	allStmt := sqlair.MustPrepare("SELECT &Item.* FROM item ORDER BY name", Item{})
+	err = db.Query(nil, allStmt).GetAll(&items)
+	c.Assert(err, ErrorMatches, `cannot populate slice: cannot get result: unexpected NULL in column "name"`)
+	var nullErr *sqlair.NullError
+	c.Assert(errors.As(err, &nullErr), Equals, true)
+	c.Assert(nullErr.Column, Equals, "name")
+
+	// In strict mode NULL is rejected for outputs that cannot be nil and
+	// have no default.
+	strictStmt := sqlair.MustPrepare("SELECT &Item.* FROM item WHERE name = 'a'", Item{}, sqlair.StrictNulls)
+	err = db.Query(nil, strictStmt).Get(&item)
+	c.Assert(err, ErrorMatches, `cannot get result: unexpected NULL in column "note"`)
+
+	strictStmt = sqlair.MustPrepare("SELECT (name, count, price, origin) AS (&Item.*) FROM item WHERE name = 'a'", Item{}, sqlair.StrictNulls)
+	c.Assert(db.Query(nil, strictStmt).Get(&item), IsNil)
+	c.Assert(item.Origin, Equals, sql.NullString{})
+
+	var note string
+	var notePtr *string
+	anonStmt := sqlair.MustPrepare("SELECT item.note AS &_ FROM item WHERE name = 'a'", sqlair.StrictNulls)
+	err = db.Query(nil, anonStmt).Get(&note)
+	c.Assert(err, ErrorMatches, `cannot get result: unexpected NULL in column "item.note"`)
+	c.Assert(db.Query(nil, anonStmt).Get(&notePtr), IsNil)
+	c.Assert(notePtr, IsNil)
+}
+
+func (s *PackageSuite) TestMapper(c *C) {
+	type Employee struct {
+		EmployeeID int
+		FullName   string
+		TeamID     int `sql:"team"`
+	}
+	createTables := "CREATE TABLE employee (employee_id integer, full_name text, team integer);"
+	sqldb, err := createExampleDB(c, createTables, nil)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, "employee")
+
+	mapper := &sqlair.Mapper{TagKey: "sql", ColumnName: sqlair.SnakeCase}
+	insertStmt := sqlair.MustPrepare("INSERT INTO employee (employee_id, full_name, team) VALUES ($Employee.employee_id, $Employee.full_name, $Employee.team)", Employee{}, mapper)
+	alice := Employee{EmployeeID: 1, FullName: "Alice", TeamID: 7}
+	c.Assert(db.Query(nil, insertStmt, alice).Run(), IsNil)
+
+	var got Employee
+	selectStmt := sqlair.MustPrepare("SELECT &Employee.* FROM employee WHERE full_name = $Employee.full_name", Employee{}, mapper)
+	c.Assert(db.Query(nil, selectStmt, Employee{FullName: "Alice"}).Get(&got), IsNil)
+	c.Assert(got, Equals, alice)
+
+	// Without a mapper the untagged fields are ignored.
+	_, err = sqlair.Prepare("SELECT &Employee.full_name FROM employee", Employee{})
+	c.Assert(err, ErrorMatches, `cannot prepare statement: output expression: type "Employee" has no "full_name" db tag: &Employee.full_name`)
+
+	sqlair.SetDefaultMapper(&sqlair.Mapper{TagKey: "sql", ColumnName: sqlair.SnakeCase})
+	defer sqlair.SetDefaultMapper(nil)
+	got = Employee{}
+	selectStmt = sqlair.MustPrepare("SELECT &Employee.* FROM employee", Employee{})
+	c.Assert(db.Query(nil, selectStmt).Get(&got), IsNil)
+	c.Assert(got, Equals, alice)
+}
+
+func (s *PackageSuite) TestRegistry(c *C) {
+	tables, sqldb, err := personAndAddressDB(c)
+	c.Assert(err, IsNil)
+	db := sqlair.NewDB(sqldb)
+	defer dropTables(c, db, tables...)
+
+	reg := db.Registry()
+	c.Assert(reg.Register(Person{}, Address{}), IsNil)
+	// Registering a type again has no effect.
+	c.Assert(reg.Register(Person{}), IsNil)
+
+	stmt, err := reg.Prepare("SELECT p.* AS &Person.*, a.district AS &Address.district FROM person AS p JOIN address AS a ON p.address_id = a.id WHERE p.name = $Person.name")
+	c.Assert(err, IsNil)
+	var p Person
+	var a Address
+	c.Assert(db.Query(nil, stmt, Person{Fullname: "Mark"}).Get(&p, &a), IsNil)
+	c.Assert(p, Equals, Person{ID: 20, Fullname: "Mark", PostalCode: 1500})
+	c.Assert(a, Equals, Address{District: "Sad World"})
+
+	// Type samples and options can be passed along with the registered types.
+	stmt, err = reg.Prepare("SELECT &Person.* FROM person WHERE name = $Manager.name", Person{}, Manager{}, sqlair.StrictNulls)
+	c.Assert(err, IsNil)
+	c.Assert(db.Query(nil, stmt, Manager{Fullname: "Fred"}).Get(&p), IsNil)
+	c.Assert(p, Equals, Person{ID: 30, Fullname: "Fred", PostalCode: 1000})
+
+	_, err = reg.Prepare("SELECT &Manager.* FROM person")
+	c.Assert(err, ErrorMatches, `cannot prepare statement: output expression: parameter with type "Manager" missing \(have "Address", "Person"\): &Manager.\*`)
+
+	err = reg.Register(sqlair.Identifiers("id"))
+	c.Assert(err, ErrorMatches, `cannot register statement options`)
+	err = reg.Register(&Person{})
+	c.Assert(err, ErrorMatches, `cannot register types: need non-pointer type, got pointer to struct`)
+
+	// A registry can also be held globally.
+	global := sqlair.NewRegistry()
+	c.Assert(global.Register(Manager{}), IsNil)
+	c.Assert(global.MustPrepare("SELECT &Manager.* FROM person"), NotNil)
+}
+
+func (s *PackageSuite) TestStatementIntrospection(c *C) {
+	type IDs []int
+	stmt, err := sqlair.Prepare(`
+SELECT p.* AS &Person.*, a.district AS &Address.district, count(*) AS &_, * AS &M.*
+FROM person AS p JOIN address AS a ON p.address_id = a.id
+WHERE p.name = $Manager.name AND a.id IN ($IDs[:]) {{ AND street = $M.street }}`, Person{}, Address{}, Manager{}, IDs{}, sqlair.M{})
+	c.Assert(err, IsNil)
+
+	intType := reflect.TypeOf(0)
+	stringType := reflect.TypeOf("")
+	anyType := reflect.TypeOf((*any)(nil)).Elem()
+	c.Assert(stmt.Inputs(), DeepEquals, []sqlair.ArgMember{
+		{TypeName: "sqlair_test.Manager", Member: "Fullname", Type: stringType, Column: "name"},
+		{TypeName: "sqlair_test.IDs", Type: intType},
+		{TypeName: "sqlair.M", Member: "street", Type: anyType, Column: "street"},
+	})
+	c.Assert(stmt.Outputs(), DeepEquals, []sqlair.ArgMember{
+		{TypeName: "sqlair_test.Person", Member: "PostalCode", Type: intType, Column: "p.address_id"},
+		{TypeName: "sqlair_test.Person", Member: "ID", Type: intType, Column: "p.id"},
+		{TypeName: "sqlair_test.Person", Member: "Fullname", Type: stringType, Column: "p.name"},
+		{TypeName: "sqlair_test.Address", Member: "District", Type: stringType, Column: "a.district"},
+		{Column: "count(*)"},
+		{TypeName: "sqlair.M", Member: "*", Type: anyType, Column: "*"},
+	})
+	c.Assert(stmt.Types(), DeepEquals, []reflect.Type{
+		reflect.TypeOf(Person{}), reflect.TypeOf(Address{}), reflect.TypeOf(sqlair.M{}), reflect.TypeOf(Manager{}), reflect.TypeOf(IDs{}),
+	})
+	c.Assert(stmt.SQLTemplate(), Equals, `
+SELECT p.address_id AS _sqlair_0, p.id AS _sqlair_1, p.name AS _sqlair_2, a.district AS _sqlair_3, count(*) AS _sqlair_4, *
+FROM person AS p JOIN address AS a ON p.address_id = a.id
+WHERE p.name = $Manager.name AND a.id IN ($IDs[:]) {{ AND street = $M.street }}`)
+
+	// The fields of a struct in a predicate have the columns they match.
+	stmt, err = sqlair.Prepare("SELECT name AS &Person.name FROM person WHERE $Address.*", Person{}, Address{})
+	c.Assert(err, IsNil)
+	c.Assert(stmt.Inputs(), DeepEquals, []sqlair.ArgMember{
+		{TypeName: "sqlair_test.Address", Member: "District", Type: stringType, Column: "district"},
+		{TypeName: "sqlair_test.Address", Member: "ID", Type: intType, Column: "id"},
+		{TypeName: "sqlair_test.Address", Member: "Street", Type: stringType, Column: "street"},
+	})
+	c.Assert(stmt.SQLTemplate(), Equals, "SELECT name AS _sqlair_0 FROM person WHERE $Address.*")
+}
+
+func (s *PackageSuite) TestPrepareCache(c *C) {
+	sqlair.SetPrepareCacheSize(2)
+	defer sqlair.SetPrepareCacheSize(0)
+
+	query := "SELECT &Person.* FROM person WHERE name = $Manager.name"
+	stmt1, err := sqlair.Prepare(query, Person{}, Manager{})
+	c.Assert(err, IsNil)
+	stmt2, err := sqlair.Prepare(query, Manager{}, Person{})
+	c.Assert(err, IsNil)
+	c.Assert(stmt1 == stmt2, Equals, false)
+	stmt3, err := sqlair.Prepare(query, Person{}, Manager{})
+	c.Assert(err, IsNil)
+	c.Assert(stmt1 == stmt3, Equals, true)
+	c.Assert(sqlair.GetPrepareCacheStats(), Equals, sqlair.PrepareCacheStats{Hits: 1, Misses: 2, Len: 2, Size: 2})
+
+	// Statements prepared with different options are cached separately.
+	orderQuery := "SELECT &Person.* FROM person ORDER BY #Manager.name"
+	stmt4, err := sqlair.Prepare(orderQuery, Person{}, Manager{}, sqlair.Identifiers("name"))
+	c.Assert(err, IsNil)
+	stmt5, err := sqlair.Prepare(orderQuery, Person{}, Manager{}, sqlair.Identifiers("id"))
+	c.Assert(err, IsNil)
+	c.Assert(stmt4 == stmt5, Equals, false)
+
+	// The least recently used statements have been evicted.
+	c.Assert(sqlair.GetPrepareCacheStats(), Equals, sqlair.PrepareCacheStats{Hits: 1, Misses: 4, Len: 2, Size: 2})
+	stmt6, err := sqlair.Prepare(query, Person{}, Manager{})
+	c.Assert(err, IsNil)
+	c.Assert(stmt1 == stmt6, Equals, false)
+
+	// Errors are not cached.
+	_, err = sqlair.Prepare("SELECT &Address.* FROM address", Person{})
+	c.Assert(err, NotNil)
+	c.Assert(sqlair.GetPrepareCacheStats().Len, Equals, 2)
+
+	sqlair.SetPrepareCacheSize(0)
+	c.Assert(sqlair.GetPrepareCacheStats(), Equals, sqlair.PrepareCacheStats{})
+}
+
 func (s *PackageSuite) TestJujuStore(c *C) {
 	var tests = []struct {
 		summary  string
@@ -1212,3 +2399,70 @@ AND    l.model_uuid = $JujuLeaseKey.model_uuid`,
 			Commentf("\ntest %q failed (Close):\ninput: %s\n", t.summary, t.query))
 	}
 }
+
+// benchmarkPersonDB returns a database with a person table of the given
+// number of rows.
+func benchmarkPersonDB(b *testing.B, rows int) *sqlair.DB {
+	sqldb, err := sql.Open("sqlite3", "file:bench.db?cache=shared&mode=memory")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { sqldb.Close() })
+	_, err = sqldb.Exec("DROP TABLE IF EXISTS person; CREATE TABLE person (name text, id integer, address_id integer, email text);")
+	if err != nil {
+		b.Fatal(err)
+	}
+	tx, err := sqldb.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		_, err := tx.Exec("INSERT INTO person VALUES (?, ?, ?, ?)", fmt.Sprintf("name%d", i), i, i*10, fmt.Sprintf("name%d@email.com", i))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return sqlair.NewDB(sqldb)
+}
+
+func BenchmarkGetAll(b *testing.B) {
+	const rows = 10000
+	db := benchmarkPersonDB(b, rows)
+	stmt := sqlair.MustPrepare("SELECT &Person.* FROM person", Person{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var people []Person
+		if err := db.Query(nil, stmt).GetAll(&people); err != nil {
+			b.Fatal(err)
+		}
+		if len(people) != rows {
+			b.Fatalf("got %d rows, want %d", len(people), rows)
+		}
+	}
+}
+
+func BenchmarkIterGet(b *testing.B) {
+	const rows = 10000
+	db := benchmarkPersonDB(b, rows)
+	stmt := sqlair.MustPrepare("SELECT &Person.* FROM person", Person{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter := db.Query(nil, stmt).Iter()
+		var p Person
+		for iter.Next() {
+			if err := iter.Get(&p); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Canonical Ltd.
+// Licensed under Apache 2.0, see LICENCE file for details.
+
+package sqlair
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/canonical/sqlair/internal/expr"
+	"github.com/canonical/sqlair/internal/typeinfo"
+)
+
+// Registry holds types that are used in the SQLair expressions of many
+// statements. Statements prepared with a Registry can reference any of its
+// types without type samples being passed to each call to Prepare.
+//
+// A Registry can be held globally or attached to a DB, and it can be used by
+// several goroutines at once.
+type Registry struct {
+	mutex       sync.RWMutex
+	typeSamples []any
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the types of the type samples to the registry. Registering a
+// type more than once has no effect. An error is returned if a type cannot
+// be used in SQLair expressions or has the same package qualified name as a
+// type already registered. Statement options cannot be registered.
+func (r *Registry) Register(typeSamples ...any) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	samples := append([]any{}, r.typeSamples...)
+	for _, ts := range typeSamples {
+		if expr.IsOption(ts) {
+			return fmt.Errorf("cannot register statement options")
+		}
+		if !hasType(samples, reflect.TypeOf(ts)) {
+			samples = append(samples, ts)
+		}
+	}
+	if _, err := typeinfo.GenerateArgInfo(samples); err != nil {
+		return fmt.Errorf("cannot register types: %s", err)
+	}
+	r.typeSamples = samples
+	return nil
+}
+
+// Prepare is the same as the package level Prepare except that the types in
+// the registry are passed as type samples. Further type samples and
+// statement options can be passed in args. Type samples of types that are
+// already registered are ignored.
+func (r *Registry) Prepare(query string, args ...any) (*Statement, error) {
+	return Prepare(query, r.typeSamplesWith(args)...)
+}
+
+// MustPrepare is the same as Prepare except that it panics on error.
+func (r *Registry) MustPrepare(query string, args ...any) *Statement {
+	s, err := r.Prepare(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// typeSamplesWith returns the registered type samples followed by args,
+// leaving out the type samples in args of registered types.
+func (r *Registry) typeSamplesWith(args []any) []any {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	samples := make([]any, len(r.typeSamples), len(r.typeSamples)+len(args))
+	copy(samples, r.typeSamples)
+	for _, arg := range args {
+		if !expr.IsOption(arg) && hasType(r.typeSamples, reflect.TypeOf(arg)) {
+			continue
+		}
+		samples = append(samples, arg)
+	}
+	return samples
+}
+
+// hasType reports whether one of the type samples has type t.
+func hasType(typeSamples []any, t reflect.Type) bool {
+	for _, ts := range typeSamples {
+		if reflect.TypeOf(ts) == t {
+			return true
+		}
+	}
+	return false
+}
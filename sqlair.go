@@ -8,9 +8,11 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
+	"sync"
 	"sync/atomic"
 
 	"github.com/canonical/sqlair/internal/expr"
+	"github.com/canonical/sqlair/internal/typeinfo"
 )
 
 // M is a type that, as with other map types, can be used with SQLair for more dynamic behavior.
@@ -24,10 +26,169 @@ import (
 //	err := q.Get(resultMap) // => sqlair.M{"name": "Fred", "postcode": 10031}
 type M map[string]any
 
+// Extra is a map type that can be provided as an output argument to receive,
+// by column name, every column of the results that is not bound to an output
+// expression.
+//
+// For example:
+//
+//	stmt := sqlair.MustPrepare("SELECT email, &Person.* FROM person", Person{})
+//	var p = Person{}
+//	var extra = sqlair.Extra{}
+//	err := db.Query(ctx, stmt).Get(&p, extra) // => extra = sqlair.Extra{"email": "fred@email.com"}
+type Extra map[string]any
+
 // S is a slice type that, as with other named slice types, can be used with
 // SQLair to pass a slice of input values.
 type S []any
 
+// Arg marks v as a positional input argument for the "$N" input expressions
+// of a query. Input arguments of types not mentioned in the query are already
+// treated as positional, Arg is needed only for values whose type is also
+// used in a struct, map or slice input expression.
+func Arg(v any) any {
+	return expr.PositionalArg{Value: v}
+}
+
+// Identifiers declares the identifiers that the "#Type.member" input
+// expressions of a statement may insert into the query. It is passed to
+// Prepare alongside the type samples:
+//
+//	stmt, err := sqlair.Prepare(
+//		"SELECT &Person.* FROM person ORDER BY #Sort.column",
+//		Person{}, Sort{}, sqlair.Identifiers("name", "created_at"),
+//	)
+func Identifiers(ids ...string) any {
+	return expr.Identifiers(ids)
+}
+
+const (
+	// EmptyInFalse is passed to Prepare to render IN clauses with an empty
+	// slice, "col IN ($S[:])", as false, and "col NOT IN ($S[:])" as true.
+	EmptyInFalse = expr.EmptyInFalse
+	// EmptyInTrue is passed to Prepare to render IN clauses with an empty
+	// slice, "col IN ($S[:])", as true, and "col NOT IN ($S[:])" as false.
+	EmptyInTrue = expr.EmptyInTrue
+)
+
+const (
+	// SQLiteLargeSlices is passed to Prepare so that a slice in an IN clause,
+	// "col IN ($S[:])", with more elements than SQLite allows query
+	// parameters is passed as a single JSON array expanded with json_each.
+	SQLiteLargeSlices = expr.LargeSlicesJSONEach
+	// PostgresLargeSlices is passed to Prepare so that a slice in an IN
+	// clause, "col IN ($S[:])", with more elements than Postgres allows query
+	// parameters is passed as a single array expanded with unnest. The
+	// database driver must support slice parameters.
+	PostgresLargeSlices = expr.LargeSlicesUnnest
+)
+
+// Dialect is passed to Prepare to specify the SQL dialect of the database.
+// It determines how struct fields with the "array" tag option are passed as
+// query parameters. The default is DialectSQLite. Large slices in IN clauses
+// are passed as they are unless SQLiteLargeSlices or PostgresLargeSlices is
+// also passed.
+type Dialect = typeinfo.Dialect
+
+const (
+	// DialectSQLite passes array fields as JSON arrays.
+	DialectSQLite = typeinfo.DialectSQLite
+	// DialectPostgres passes array fields as Postgres array literals.
+	DialectPostgres = typeinfo.DialectPostgres
+)
+
+// StrictNulls is passed to Prepare so that reading NULL into an output that
+// cannot be set to nil, such as a string struct field, returns a *NullError
+// instead of setting the output to its zero value. Fields with a default
+// value, given by the "default=" tag option, are set to the default.
+const StrictNulls = expr.NullResultsError
+
+// NullError is returned when NULL is read into a struct field with the
+// "notnull" tag option, or into an output that cannot be set to nil by a
+// statement prepared with StrictNulls.
+type NullError = typeinfo.NullError
+
+// EmptySliceError is returned when the slice in an IN clause,
+// "col IN ($S[:])", is empty and the statement was not prepared with
+// EmptyInFalse or EmptyInTrue.
+type EmptySliceError = expr.EmptySliceError
+
+// RegisterConverter registers functions to convert values of the Go type T
+// to and from values supported by the database driver, for types that do not
+// implement driver.Valuer and sql.Scanner, such as types from other modules.
+// The converter is used for struct fields of type T or *T in input and output
+// expressions, and for anonymous outputs of those types. fromDB is not called
+// for NULL results, which set the value to the zero value of T, or to nil for
+// *T.
+//
+// For example:
+//
+//	sqlair.RegisterConverter(
+//		func(a netip.Addr) (any, error) { return a.String(), nil },
+//		func(src any) (netip.Addr, error) {
+//			s, ok := src.(string)
+//			if !ok {
+//				return netip.Addr{}, fmt.Errorf("cannot convert %T to netip.Addr", src)
+//			}
+//			return netip.ParseAddr(s)
+//		},
+//	)
+func RegisterConverter[T any](toDB func(T) (any, error), fromDB func(any) (T, error)) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	typeinfo.RegisterConverter(t, typeinfo.Converter{
+		ToDB: func(v reflect.Value) (any, error) {
+			return toDB(v.Interface().(T))
+		},
+		FromDB: func(src any) (reflect.Value, error) {
+			v, err := fromDB(src)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(&v).Elem(), nil
+		},
+	})
+}
+
+// Mapper specifies how the fields of struct types are mapped to columns. A
+// Mapper can be passed to Prepare alongside the type samples, and otherwise
+// the default mapper set with SetDefaultMapper is used.
+//
+// For example, to read the columns of fields from "sql" tags and map the
+// fields without a tag to snake case columns:
+//
+//	mapper := &sqlair.Mapper{TagKey: "sql", ColumnName: sqlair.SnakeCase}
+//	stmt, err := sqlair.Prepare("SELECT &Person.* FROM person", Person{}, mapper)
+//
+// A Mapper must not be modified after it is first used. The type information
+// generated with each Mapper is cached separately, keyed by its pointer, and
+// is never evicted. Each *Mapper should therefore be created once, for
+// example in a package level variable, and reused rather than created for
+// each call to Prepare.
+type Mapper = typeinfo.Mapper
+
+// SetDefaultMapper sets the Mapper used by Prepare when none is passed to it.
+// If m is nil, the columns of fields are read from "db" tags and fields
+// without a tag are ignored. The statements in the Prepare cache are
+// discarded.
+func SetDefaultMapper(m *Mapper) {
+	typeinfo.SetDefaultMapper(m)
+	if c := getPrepareCache(); c != nil {
+		c.clear()
+	}
+}
+
+// SnakeCase maps a Go field name to a snake case column name, e.g. "UserID"
+// to "user_id". It can be used as the ColumnName of a Mapper.
+func SnakeCase(name string) string {
+	return typeinfo.SnakeCase(name)
+}
+
+// LowerCase maps a Go field name to a lower case column name, e.g. "UserID"
+// to "userid". It can be used as the ColumnName of a Mapper.
+func LowerCase(name string) string {
+	return typeinfo.LowerCase(name)
+}
+
 var ErrNoRows = sql.ErrNoRows
 var ErrTXDone = sql.ErrTxDone
 
@@ -44,7 +205,26 @@ type Statement struct {
 // the SQLair parts of the query are well formed.
 // typeSamples must contain an instance of every type mentioned in the
 // SQLair expressions of the query. These are used only for type information.
+// If the Prepare cache is enabled with SetPrepareCacheSize, a cached
+// statement may be returned.
 func Prepare(query string, typeSamples ...any) (*Statement, error) {
+	c := getPrepareCache()
+	if c == nil {
+		return prepare(query, typeSamples...)
+	}
+	if s, ok := c.get(query, typeSamples); ok {
+		return s, nil
+	}
+	s, err := prepare(query, typeSamples...)
+	if err != nil {
+		return nil, err
+	}
+	c.add(query, typeSamples, s)
+	return s, nil
+}
+
+// prepare parses the query and binds the types of the type samples to it.
+func prepare(query string, typeSamples ...any) (*Statement, error) {
 	parser := expr.NewParser()
 	parsedExpr, err := parser.Parse(query)
 	if err != nil {
@@ -67,13 +247,52 @@ func MustPrepare(query string, typeSamples ...any) *Statement {
 	return s
 }
 
+// ArgMember describes a member of an input or output argument of a
+// statement: the name of the argument type, the struct field or map key, its
+// Go type and its column.
+type ArgMember = expr.ArgMember
+
+// Inputs returns the members of the input arguments referenced in the
+// statement, in the order they appear. Every field of a struct in a "$Type.*"
+// predicate is included. Positional inputs are not included.
+func (s *Statement) Inputs() []ArgMember {
+	return s.te.Inputs()
+}
+
+// Outputs returns the members of the output arguments that the results of
+// the statement are read into, in the order of the columns.
+func (s *Statement) Outputs() []ArgMember {
+	return s.te.Outputs()
+}
+
+// Types returns the types of the input and output arguments referenced in
+// the statement, in the order they first appear.
+func (s *Statement) Types() []reflect.Type {
+	return s.te.Types()
+}
+
+// SQLTemplate returns the SQL of the statement with the output expressions
+// expanded into the columns they fetch and the input expressions left as
+// they are written. The SQL sent to the database when the statement is run
+// has the input expressions replaced with query parameters.
+func (s *Statement) SQLTemplate() string {
+	return s.te.SQLTemplate()
+}
+
 type DB struct {
-	sqldb *sql.DB
+	sqldb    *sql.DB
+	registry *Registry
 }
 
 // NewDB creates a new SQLair DB from a sql.DB.
 func NewDB(sqldb *sql.DB) *DB {
-	return &DB{sqldb: sqldb}
+	return &DB{sqldb: sqldb, registry: NewRegistry()}
+}
+
+// Registry returns the type registry attached to the database. It is empty
+// when the DB is created.
+func (db *DB) Registry() *Registry {
+	return db.registry
 }
 
 // PlainDB returns the underlying database object.
@@ -81,23 +300,74 @@ func (db *DB) PlainDB() *sql.DB {
 	return db.sqldb
 }
 
+// BoundStatement is a Statement bound to a DB, for running the statement
+// many times. The SQL generated for the previous query is reused when the
+// input arguments of the next query generate the same SQL, so that only the
+// query parameters are refreshed.
+type BoundStatement struct {
+	s  *Statement
+	db *DB
+
+	mutex sync.Mutex
+	// last is the primed query of the previous successful call to Query.
+	last *expr.PrimedQuery
+}
+
+// Bind returns a BoundStatement to run the Statement on db.
+func (s *Statement) Bind(db *DB) *BoundStatement {
+	return &BoundStatement{s: s, db: db}
+}
+
+// Query takes a context and the input arguments of the statement and
+// returns a Query object for iterating over the results. It is the same as
+// DB.Query except that the SQL of the previous query is reused if possible.
+func (bs *BoundStatement) Query(ctx context.Context, inputArgs ...any) *Query {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	bs.mutex.Lock()
+	last := bs.last
+	bs.mutex.Unlock()
+
+	q := (&Query{te: bs.s.te, pq: last, run: bs.db.run, ctx: ctx}).Rebind(inputArgs...)
+	if q.err == nil {
+		bs.mutex.Lock()
+		bs.last = q.pq
+		bs.mutex.Unlock()
+	}
+	return q
+}
+
 // Query holds the results of a database query.
 type Query struct {
-	// run executes the Query against the db or the tx.
-	run func(context.Context) (*sql.Rows, sql.Result, error)
+	// run executes a primed query against the db or the tx.
+	run func(context.Context, *expr.PrimedQuery) (*sql.Rows, sql.Result, error)
 	ctx context.Context
 	err error
-	pq  *expr.PrimedQuery
+	// te is the type bound SQLair query the Query was primed from. It is
+	// used to bind new input arguments with Rebind.
+	te *expr.TypeBoundExpr
+	pq *expr.PrimedQuery
+	// strict is true if columns of the results that are not bound to an
+	// output expression should cause an error.
+	strict bool
 }
 
 // Iterator is used to iterate over the results of the query.
 type Iterator struct {
 	pq      *expr.PrimedQuery
 	rows    *sql.Rows
-	cols    []string
+	cols    []*sql.ColumnType
 	err     error
 	result  sql.Result
 	started bool
+	strict  bool
+	// plan is the plan for scanning rows into the output arguments of the
+	// previous call to Get.
+	plan *expr.ScanPlan
+	// args is the reusable list of output arguments passed to the plan.
+	args []any
 }
 
 // Query takes a context, prepared SQLair Statement and the structs mentioned in the query arguments.
@@ -108,20 +378,55 @@ func (db *DB) Query(ctx context.Context, s *Statement, inputArgs ...any) *Query
 	}
 
 	pq, err := s.te.BindInputs(inputArgs...)
-	if err != nil {
-		return &Query{ctx: ctx, err: err}
+	return &Query{te: s.te, pq: pq, run: db.run, ctx: ctx, err: err}
+}
+
+// run executes a primed query against the database.
+func (db *DB) run(ctx context.Context, pq *expr.PrimedQuery) (*sql.Rows, sql.Result, error) {
+	return runPrimedQuery(ctx, db.sqldb, pq)
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// runPrimedQuery executes a primed query with the querier. Queries with
+// output expressions return rows, other queries return a result.
+func runPrimedQuery(ctx context.Context, q querier, pq *expr.PrimedQuery) (rows *sql.Rows, result sql.Result, err error) {
+	if pq.HasOutputs() {
+		rows, err = q.QueryContext(ctx, pq.SQL(), pq.Params()...)
+	} else {
+		result, err = q.ExecContext(ctx, pq.SQL(), pq.Params()...)
 	}
+	return rows, result, err
+}
 
-	run := func(innerCtx context.Context) (rows *sql.Rows, result sql.Result, err error) {
-		if pq.HasOutputs() {
-			rows, err = db.sqldb.QueryContext(innerCtx, pq.SQL(), pq.Params()...)
-		} else {
-			result, err = db.sqldb.ExecContext(innerCtx, pq.SQL(), pq.Params()...)
-		}
-		return rows, result, err
+// Rebind returns a copy of the Query with new input arguments. If the new
+// input arguments generate the same SQL as those of the Query, for example
+// because their slices have the same lengths, the SQL is not generated again
+// and only the query parameters are refreshed.
+func (q *Query) Rebind(inputArgs ...any) *Query {
+	if q.te == nil {
+		return q
+	}
+	rq := *q
+	if q.pq != nil {
+		rq.pq, rq.err = q.te.RebindInputs(q.pq, inputArgs...)
+	} else {
+		rq.pq, rq.err = q.te.BindInputs(inputArgs...)
 	}
+	return &rq
+}
 
-	return &Query{pq: pq, run: run, ctx: ctx, err: nil}
+// Strict returns a copy of the Query that fails to get results containing
+// columns that are not bound to an output expression, unless an Extra map is
+// provided to receive them.
+func (q *Query) Strict() *Query {
+	sq := *q
+	sq.strict = true
+	return &sq
 }
 
 // Run is an alias for Get that takes no arguments.
@@ -174,18 +479,18 @@ func (q *Query) Iter() *Iterator {
 		return &Iterator{err: q.err}
 	}
 
-	var cols []string
-	rows, result, err := q.run(q.ctx)
+	var cols []*sql.ColumnType
+	rows, result, err := q.run(q.ctx, q.pq)
 	if q.pq.HasOutputs() {
 		if err == nil { // if err IS nil
-			cols, err = rows.Columns()
+			cols, err = rows.ColumnTypes()
 		}
 	}
 	if err != nil {
 		return &Iterator{pq: q.pq, err: err}
 	}
 
-	return &Iterator{pq: q.pq, rows: rows, cols: cols, err: err, result: result}
+	return &Iterator{pq: q.pq, rows: rows, cols: cols, err: err, result: result, strict: q.strict}
 }
 
 // Next prepares the next row for Get.
@@ -198,6 +503,23 @@ func (iter *Iterator) Next() bool {
 	return iter.rows.Next()
 }
 
+// NextResultSet prepares the next result set of the query for reading with
+// Next and Get, for queries that return several result sets. It reports
+// whether there is another result set. Each result set can contain the
+// columns of a different subset of the output expressions of the statement,
+// and only the output arguments for those need to be passed to Get.
+func (iter *Iterator) NextResultSet() bool {
+	if iter.err != nil || iter.rows == nil {
+		return false
+	}
+	if !iter.rows.NextResultSet() {
+		return false
+	}
+	iter.cols, iter.err = iter.rows.ColumnTypes()
+	iter.plan = nil
+	return iter.err == nil
+}
+
 // Get decodes the result from the previous Next call into the provided output arguments.
 // An &Outcome{} variable may be provided as the single output variable before the first call to Next.
 func (iter *Iterator) Get(outputArgs ...any) (err error) {
@@ -206,7 +528,7 @@ func (iter *Iterator) Get(outputArgs ...any) (err error) {
 	}
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("cannot get result: %s", err)
+			err = fmt.Errorf("cannot get result: %w", err)
 		}
 	}()
 
@@ -224,15 +546,33 @@ func (iter *Iterator) Get(outputArgs ...any) (err error) {
 		return fmt.Errorf("iteration ended")
 	}
 
-	ptrs, onSuccess, err := iter.pq.ScanArgs(iter.cols, outputArgs)
-	if err != nil {
-		return err
+	var extra Extra
+	args := iter.args[:0]
+	for _, arg := range outputArgs {
+		if e, ok := arg.(Extra); ok {
+			if e == nil {
+				return fmt.Errorf("got nil Extra")
+			}
+			if extra != nil {
+				return fmt.Errorf(`type "Extra" provided more than once`)
+			}
+			extra = e
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	iter.args = args
+	if iter.plan == nil || !iter.plan.Matches(args, extra) {
+		iter.plan, err = iter.pq.ScanPlan(iter.cols, args, extra, iter.strict)
+		if err != nil {
+			return err
+		}
 	}
-	if err := iter.rows.Scan(ptrs...); err != nil {
+	if err := iter.rows.Scan(iter.plan.ScanArgs(args, extra)...); err != nil {
 		return err
 	}
-	onSuccess()
-	return nil
+	return iter.plan.OnSuccess(extra)
 }
 
 // Close finishes the iteration and returns any errors encountered.
@@ -260,7 +600,11 @@ func (o *Outcome) Result() sql.Result {
 }
 
 // GetAll iterates over the query and scans all rows into the provided slices.
-// sliceArgs must contain pointers to slices of each of the output types.
+// sliceArgs must contain pointers to slices of each of the output types. If
+// the query contains anonymous outputs, slices of other types are filled from
+// them in order.
+// If the query returns several result sets, each slice is filled from the
+// result sets containing the columns of its outputs.
 // An &Outcome{} variable may be provided as the first output variable.
 func (q *Query) GetAll(sliceArgs ...any) (err error) {
 	if q.err != nil {
@@ -268,7 +612,7 @@ func (q *Query) GetAll(sliceArgs ...any) (err error) {
 	}
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("cannot populate slice: %s", err)
+			err = fmt.Errorf("cannot populate slice: %w", err)
 		}
 	}()
 
@@ -300,43 +644,88 @@ func (q *Query) GetAll(sliceArgs ...any) (err error) {
 		sliceVals = append(sliceVals, sliceVal)
 	}
 
+	// appendElem records, for each slice, whether rows are scanned into a
+	// new element appended to the slice rather than into a new value that is
+	// then appended.
+	var appendElem = []bool{}
+	// outputTypes records the output type of the elements of each slice of
+	// structs or maps. It is nil for slices filled from anonymous outputs.
+	var outputTypes = []reflect.Type{}
+	for _, sliceVal := range sliceVals {
+		elemType := sliceVal.Type().Elem()
+		switch k := elemType.Kind(); {
+		case k == reflect.Pointer && elemType.Elem().Kind() == reflect.Struct:
+			appendElem = append(appendElem, false)
+			outputTypes = append(outputTypes, elemType.Elem())
+		case k == reflect.Struct:
+			appendElem = append(appendElem, true)
+			outputTypes = append(outputTypes, elemType)
+		case k == reflect.Map:
+			appendElem = append(appendElem, false)
+			outputTypes = append(outputTypes, elemType)
+		case q.pq.HasAnonymousOutputs():
+			// Slices of other types are filled from anonymous outputs.
+			appendElem = append(appendElem, true)
+			outputTypes = append(outputTypes, nil)
+		case k == reflect.Pointer:
+			return fmt.Errorf("need slice of structs/maps, got slice of pointer to %s", elemType.Elem().Kind())
+		default:
+			return fmt.Errorf("need slice of structs/maps, got slice of %s", k)
+		}
+	}
+
+	queryOutputTypes := q.pq.OutputTypes()
+	// anonymousFilled is the number of slices filled from the anonymous
+	// outputs of previous result sets.
+	anonymousFilled := 0
 	iter := q.Iter()
-	for iter.Next() {
-		var outputArgs = []any{}
-		for _, sliceVal := range sliceVals {
-			elemType := sliceVal.Type().Elem()
-			var outputArg reflect.Value
-			switch elemType.Kind() {
-			case reflect.Pointer:
-				if elemType.Elem().Kind() != reflect.Struct {
-					iter.Close()
-					return fmt.Errorf("need slice of structs/maps, got slice of pointer to %s", elemType.Elem().Kind())
+	for {
+		// Each result set fills the slices of the outputs with columns in
+		// it. Slices of types not in the query are passed on to Get so that
+		// it reports the error.
+		resultTypes, anonymous := q.pq.ResultSetOutputs(iter.cols)
+		var filled []int
+		anonymousSeen := 0
+		for i, t := range outputTypes {
+			if t == nil {
+				if anonymousSeen >= anonymousFilled && anonymousSeen < anonymousFilled+anonymous {
+					filled = append(filled, i)
 				}
-				outputArg = reflect.New(elemType.Elem())
-			case reflect.Struct:
-				outputArg = reflect.New(elemType)
-			case reflect.Map:
-				outputArg = reflect.MakeMap(elemType)
-			default:
-				iter.Close()
-				return fmt.Errorf("need slice of structs/maps, got slice of %s", elemType.Kind())
+				anonymousSeen++
+			} else if resultTypes[t] || !queryOutputTypes[t] {
+				filled = append(filled, i)
 			}
-			outputArgs = append(outputArgs, outputArg.Interface())
-		}
-		if err := iter.Get(outputArgs...); err != nil {
-			iter.Close()
-			return err
 		}
-		for i, outputArg := range outputArgs {
-			switch k := sliceVals[i].Type().Elem().Kind(); k {
-			case reflect.Pointer, reflect.Map:
-				sliceVals[i] = reflect.Append(sliceVals[i], reflect.ValueOf(outputArg))
-			case reflect.Struct:
-				sliceVals[i] = reflect.Append(sliceVals[i], reflect.ValueOf(outputArg).Elem())
-			default:
+		anonymousFilled += anonymous
+
+		var outputArgs = make([]any, len(filled))
+		for iter.Next() {
+			for j, i := range filled {
+				sliceVal := sliceVals[i]
+				elemType := sliceVal.Type().Elem()
+				switch {
+				case appendElem[i]:
+					// The row is scanned directly into the new element.
+					sliceVals[i] = reflect.Append(sliceVal, reflect.Zero(elemType))
+					outputArgs[j] = sliceVals[i].Index(sliceVals[i].Len() - 1).Addr().Interface()
+				case elemType.Kind() == reflect.Map:
+					outputArgs[j] = reflect.MakeMap(elemType).Interface()
+				default:
+					outputArgs[j] = reflect.New(elemType.Elem()).Interface()
+				}
+			}
+			if err := iter.Get(outputArgs...); err != nil {
 				iter.Close()
-				return fmt.Errorf("internal error: output arg has unexpected kind %s", k)
+				return err
 			}
+			for j, i := range filled {
+				if !appendElem[i] {
+					sliceVals[i] = reflect.Append(sliceVals[i], reflect.ValueOf(outputArgs[j]))
+				}
+			}
+		}
+		if !iter.NextResultSet() {
+			break
 		}
 	}
 	err = iter.Close()
@@ -423,18 +812,282 @@ func (tx *TX) Query(ctx context.Context, s *Statement, inputArgs ...any) *Query
 	}
 
 	pq, err := s.te.BindInputs(inputArgs...)
+	return &Query{te: s.te, pq: pq, run: tx.run, ctx: ctx, err: err}
+}
+
+// run executes a primed query against the transaction.
+func (tx *TX) run(ctx context.Context, pq *expr.PrimedQuery) (*sql.Rows, sql.Result, error) {
+	return runPrimedQuery(ctx, tx.sqltx, pq)
+}
+
+// BatchOutcome holds the aggregated results of the executions of a statement
+// by ExecMany.
+type BatchOutcome struct {
+	// Executions is the number of sets of input arguments the statement was
+	// executed for, including those that failed.
+	Executions int
+	// RowsAffected is the total number of rows affected by the successful
+	// executions.
+	RowsAffected int64
+	// Errors maps the index of each set of input arguments that failed to
+	// its error.
+	Errors map[int]error
+}
+
+// ExecMany executes the statement, which must not contain output
+// expressions, once for each set of input arguments yielded by inputs.
+// The SQL of the statement is prepared on the database once and reused for
+// every set of input arguments that generates the same SQL.
+//
+// The executions run in a transaction that is committed if all of them
+// succeed and rolled back otherwise. The executions continue after a set of
+// input arguments fails, so that the returned BatchOutcome holds the error of
+// each set that failed, unless ExecStopOnError is passed in opts. Some
+// databases, such as Postgres, abort the transaction when a statement fails,
+// and every later execution then fails too.
+//
+// For example:
+//
+//	outcome, err := db.ExecMany(ctx, stmt, func(yield func([]any) bool) {
+//		for _, p := range people {
+//			if !yield([]any{p}) {
+//				return
+//			}
+//		}
+//	})
+func (db *DB) ExecMany(ctx context.Context, s *Statement, inputs func(yield func(inputArgs []any) bool), opts ...ExecOption) (*BatchOutcome, error) {
+	tx, err := db.Begin(ctx, nil)
 	if err != nil {
-		return &Query{ctx: ctx, err: err}
+		return nil, err
+	}
+	outcome, err := tx.ExecMany(ctx, s, inputs, opts...)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return outcome, fmt.Errorf("%s (rollback failed: %s)", err, rerr)
+		}
+		return outcome, err
 	}
+	return outcome, tx.Commit()
+}
 
-	run := func(innerCtx context.Context) (rows *sql.Rows, result sql.Result, err error) {
-		if pq.HasOutputs() {
-			rows, err = tx.sqltx.QueryContext(innerCtx, pq.SQL(), pq.Params()...)
+// ExecMany executes the statement, which must not contain output
+// expressions, in the transaction once for each set of input arguments
+// yielded by inputs. It is the same as DB.ExecMany except that the
+// transaction is not committed or rolled back.
+func (tx *TX) ExecMany(ctx context.Context, s *Statement, inputs func(yield func(inputArgs []any) bool), opts ...ExecOption) (*BatchOutcome, error) {
+	stopOnError := false
+	for _, opt := range opts {
+		stopOnError = stopOnError || opt == ExecStopOnError
+	}
+	return tx.execMany(ctx, s, inputs, stopOnError)
+}
+
+// execMany executes the statement in the transaction once for each set of
+// input arguments. If stopOnError is true, no more sets are executed after
+// one fails.
+func (tx *TX) execMany(ctx context.Context, s *Statement, inputs func(yield func(inputArgs []any) bool), stopOnError bool) (outcome *BatchOutcome, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tx.isDone() {
+		return nil, ErrTXDone
+	}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cannot execute batch: %w", err)
+		}
+	}()
+
+	outcome = &BatchOutcome{Errors: map[int]error{}}
+	// stmts holds the prepared statements by SQL.
+	stmts := map[string]*sql.Stmt{}
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+	var pq *expr.PrimedQuery
+	inputs(func(inputArgs []any) bool {
+		i := outcome.Executions
+		outcome.Executions++
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var next *expr.PrimedQuery
+		var ierr error
+		if pq != nil {
+			next, ierr = s.te.RebindInputs(pq, inputArgs...)
 		} else {
-			result, err = tx.sqltx.ExecContext(innerCtx, pq.SQL(), pq.Params()...)
+			next, ierr = s.te.BindInputs(inputArgs...)
+		}
+		if ierr != nil {
+			outcome.Errors[i] = ierr
+			return !stopOnError
+		}
+		pq = next
+		if pq.HasOutputs() {
+			err = fmt.Errorf("statement contains output expressions")
+			return false
+		}
+		stmt, ok := stmts[pq.SQL()]
+		if !ok {
+			stmt, ierr = tx.sqltx.PrepareContext(ctx, pq.SQL())
+			if ierr != nil {
+				outcome.Errors[i] = ierr
+				return !stopOnError
+			}
+			stmts[pq.SQL()] = stmt
 		}
-		return rows, result, err
+		result, ierr := stmt.ExecContext(ctx, pq.Params()...)
+		if ierr != nil {
+			outcome.Errors[i] = ierr
+			return !stopOnError
+		}
+		n, ierr := result.RowsAffected()
+		if ierr != nil {
+			outcome.Errors[i] = ierr
+			return !stopOnError
+		}
+		outcome.RowsAffected += n
+		return true
+	})
+	if err != nil {
+		return outcome, err
 	}
+	if len(outcome.Errors) > 0 {
+		first := outcome.Executions
+		for i := range outcome.Errors {
+			if i < first {
+				first = i
+			}
+		}
+		return outcome, fmt.Errorf("%d of %d executions failed, first error at %d: %w", len(outcome.Errors), outcome.Executions, first, outcome.Errors[first])
+	}
+	return outcome, nil
+}
+
+// ExecOption is an option passed to DB.Exec alongside the input arguments,
+// or to ExecMany.
+type ExecOption int
+
+const (
+	// ExecInTransaction is passed to DB.Exec to run all the statements of
+	// the script in one transaction.
+	ExecInTransaction ExecOption = iota + 1
+	// ExecStopOnError is passed to DB.ExecMany or TX.ExecMany to stop the
+	// executions at the first set of input arguments that fails.
+	ExecStopOnError
+)
+
+// Exec runs a script of SQLair statements separated by semicolons, returning
+// an Outcome for each statement. The statements must not contain output
+// expressions. Each statement is prepared with the input arguments as type
+// samples and run with the input arguments of the types it references.
+// Positional input expressions cannot be used in scripts.
+//
+// If ExecInTransaction is passed with the input arguments, the statements are
+// run in one transaction that is committed if all of them succeed and rolled
+// back otherwise. Otherwise, the statements that ran before a failing
+// statement are not undone.
+//
+// For example:
+//
+//	outcomes, err := db.Exec(ctx, `
+//		INSERT INTO person (name, id) VALUES ($Person.name, $Person.id);
+//		INSERT INTO address (id, street) VALUES ($Address.id, $Address.street);
+//	`, person, address)
+func (db *DB) Exec(ctx context.Context, script string, inputArgs ...any) ([]*Outcome, error) {
+	args, inTransaction := splitExecOptions(inputArgs)
+	if !inTransaction {
+		return execScript(ctx, db.run, script, args)
+	}
+
+	tx, err := db.Begin(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	outcomes, err := tx.Exec(ctx, script, args...)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return outcomes, fmt.Errorf("%s (rollback failed: %s)", err, rerr)
+		}
+		return outcomes, err
+	}
+	return outcomes, tx.Commit()
+}
+
+// Exec runs a script of SQLair statements separated by semicolons in the
+// transaction, returning an Outcome for each statement. It is the same as
+// DB.Exec except that the statements always run in the transaction, so
+// ExecInTransaction has no effect.
+func (tx *TX) Exec(ctx context.Context, script string, inputArgs ...any) ([]*Outcome, error) {
+	if tx.isDone() {
+		return nil, ErrTXDone
+	}
+	args, _ := splitExecOptions(inputArgs)
+	return execScript(ctx, tx.run, script, args)
+}
 
-	return &Query{pq: pq, ctx: ctx, run: run, err: nil}
+// splitExecOptions separates the ExecOption values from the input arguments
+// passed to Exec and reports whether ExecInTransaction was among them.
+func splitExecOptions(inputArgs []any) (args []any, inTransaction bool) {
+	for _, arg := range inputArgs {
+		if opt, ok := arg.(ExecOption); ok {
+			inTransaction = inTransaction || opt == ExecInTransaction
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args, inTransaction
+}
+
+// execScript splits the script into statements and runs them in order with
+// run. The outcomes of the statements that ran are returned along with any
+// error.
+func execScript(ctx context.Context, run func(context.Context, *expr.PrimedQuery) (*sql.Rows, sql.Result, error), script string, inputArgs []any) ([]*Outcome, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parser := expr.NewParser()
+	queries, err := parser.Split(script)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse script: %s", err)
+	}
+
+	// The input arguments are also the type samples for the statements.
+	var typeSamples []any
+	for _, arg := range inputArgs {
+		if v := reflect.ValueOf(arg); v.Kind() == reflect.Pointer && !v.IsNil() {
+			arg = v.Elem().Interface()
+		}
+		typeSamples = append(typeSamples, arg)
+	}
+
+	var outcomes []*Outcome
+	for i, query := range queries {
+		s, err := Prepare(query, typeSamples...)
+		if err != nil {
+			return outcomes, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		inputTypes := s.te.InputTypes()
+		var args []any
+		for j, arg := range inputArgs {
+			if inputTypes[reflect.TypeOf(typeSamples[j])] {
+				args = append(args, arg)
+			}
+		}
+		pq, err := s.te.BindInputs(args...)
+		if err != nil {
+			return outcomes, fmt.Errorf("cannot run statement %d: %w", i+1, err)
+		}
+		if pq.HasOutputs() {
+			return outcomes, fmt.Errorf("cannot run statement %d: output expressions cannot be used in scripts", i+1)
+		}
+		_, result, err := run(ctx, pq)
+		if err != nil {
+			return outcomes, fmt.Errorf("cannot run statement %d: %w", i+1, err)
+		}
+		outcomes = append(outcomes, &Outcome{result: result})
+	}
+	return outcomes, nil
 }